@@ -12,6 +12,17 @@ func ReadBytes(r io.Reader, num int64) []byte {
 	return bytes[:n]
 }
 
+// Like ReadBytes, but returns io.ErrUnexpectedEOF
+// if fewer than num bytes could be read.
+func ReadFull(r io.Reader, num int64) ([]byte, error) {
+	bytes := make([]byte, num)
+	n, err := io.ReadFull(r, bytes)
+	if err == io.EOF && num > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return bytes[:n], err
+}
+
 func ReadBytesAt(r io.ReaderAt, num int64, offset int64) []byte {
 	bytes := make([]byte, num)
 	n, _ := r.ReadAt(bytes, offset)
@@ -23,6 +34,22 @@ func ReadUvarint(r io.ByteReader) int64 {
 	return int64(i)
 }
 
+// Like ReadUvarint, but returns any error encountered.
+func ReadUvarintChecked(r io.ByteReader) (int64, error) {
+	i, err := binary.ReadUvarint(r)
+	return int64(i), err
+}
+
+func ReadVarint(r io.ByteReader) int64 {
+	i, _ := binary.ReadVarint(r)
+	return i
+}
+
+// Like ReadVarint, but returns any error encountered.
+func ReadVarintChecked(r io.ByteReader) (int64, error) {
+	return binary.ReadVarint(r)
+}
+
 func ReadInt16(r io.Reader) int64 {
 	var i uint16
 	binary.Read(r, binary.LittleEndian, &i)
@@ -51,7 +78,7 @@ func ReadInt64(r io.Reader) int64 {
 }
 
 func ReadInt64At(r io.ReaderAt, offset int64) int64 {
-	b := ReadBytesAt(r, 4, offset)
+	b := ReadBytesAt(r, 8, offset)
 	buf := bytes.NewBuffer(b)
 
 	var i int64
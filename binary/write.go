@@ -18,6 +18,12 @@ func WriteUvarint64(w io.Writer, num int64) {
 	w.Write(b[:n])
 }
 
+func WriteVarint(w io.Writer, num int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(b, num)
+	w.Write(b[:n])
+}
+
 func WriteInt16(w io.Writer, num int) {
 	binary.Write(w, binary.LittleEndian, uint16(num))
 }
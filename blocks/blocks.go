@@ -11,7 +11,88 @@ you'd use `byte.Buffer`, `byte.Reader`, `bufio.Reader`, `bufio.Writer`, etc.
 */
 package blocks
 
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
 const (
 	NO_COMPRESSION     = iota
 	SNAPPY_COMPRESSION = iota
+	FLATE_COMPRESSION  = iota
 )
+
+// Set on a block's encoding byte when the block's data is
+// followed by a CRC32C checksum. Blocks written before
+// checksums were introduced never have it set.
+const CHECKSUM_FLAG = 0x80
+
+// Length of the checksum trailing a checksummed block.
+const checksumLen = 4
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is returned when reading a block whose
+// data doesn't match the checksum stored alongside it.
+type ErrChecksumMismatch struct {
+	// Offset of the block's header in the underlying reader.
+	Offset int64
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("blocks: checksum mismatch for block at offset %d", e.Offset)
+}
+
+// ErrCorruptBlock is returned when a block's data
+// can't be decoded using its encoding.
+type ErrCorruptBlock struct {
+	// Offset of the block's header in the underlying reader.
+	Offset int64
+	Err    error
+}
+
+func (e *ErrCorruptBlock) Error() string {
+	return fmt.Sprintf("blocks: corrupt block at offset %d: %v", e.Offset, e.Err)
+}
+
+// The CRC32C of a block's encoded data and encoding byte.
+func checksum(block []byte, encoding byte) uint32 {
+	c := crc32.Update(0, table, block)
+	return crc32.Update(c, table, []byte{encoding})
+}
+
+// Decodes a block's raw data (and checksum, if it has one) as read
+// following its header at the given offset. Compressed blocks are
+// decompressed into snapbuf, which is grown if needed.
+func decodeBlock(raw []byte, encoding int, snapbuf *[]byte, offset int64) ([]byte, error) {
+	body := raw
+
+	if encoding&CHECKSUM_FLAG != 0 {
+		body = raw[:len(raw)-checksumLen]
+
+		if checksum(body, byte(encoding)) != binary.LittleEndian.Uint32(raw[len(body):]) {
+			return nil, &ErrChecksumMismatch{offset}
+		}
+
+		encoding &^= CHECKSUM_FLAG
+	}
+
+	if encoding == NO_COMPRESSION {
+		return body, nil
+	}
+
+	codec, ok := LookupCodec(encoding)
+	if !ok {
+		return nil, &ErrCorruptBlock{offset, fmt.Errorf("%w %d", ErrUnknownEncoding, encoding)}
+	}
+
+	decoded, err := codec.Decode(*snapbuf, body)
+	if err != nil {
+		return nil, &ErrCorruptBlock{offset, err}
+	}
+
+	*snapbuf = decoded
+
+	return decoded, nil
+}
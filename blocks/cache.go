@@ -0,0 +1,112 @@
+package blocks
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache holds recently read blocks in memory, evicting the least
+// recently used blocks once it holds more than its capacity, so
+// blocks which are read again and again are only read from the
+// underlying reader, and decompressed, once.
+//
+// Cache is safe to use from multiple goroutines, and can be shared
+// by any number of readers, of any number of files.
+type Cache struct {
+	capacity int64
+	size     int64
+	entries  map[CacheKey]*list.Element
+	recent   *list.List
+	hits     int64
+	misses   int64
+	lock     sync.Mutex
+}
+
+// Identifies a cached block by the file it was read from,
+// and its offset within the file.
+type CacheKey struct {
+	File   uint64
+	Offset int64
+}
+
+type CacheStats struct {
+	// The number of blocks found in the cache.
+	Hits int64
+
+	// The number of blocks which weren't found in the
+	// cache, so had to be read from the underlying reader.
+	Misses int64
+
+	// The number of blocks, and bytes of their
+	// data, currently held in the cache.
+	Blocks int
+	Bytes  int64
+}
+
+type cacheEntry struct {
+	key  CacheKey
+	data []byte
+}
+
+// Creates a cache which holds up to capacity bytes of block data.
+func NewCache(capacity int64) *Cache {
+	return &Cache{
+		capacity: capacity,
+		entries:  make(map[CacheKey]*list.Element),
+		recent:   list.New(),
+	}
+}
+
+// Returns the data of a cached block, which must not be modified.
+func (c *Cache) Get(key CacheKey) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses += 1
+		return nil, false
+	}
+
+	c.hits += 1
+	c.recent.MoveToFront(element)
+
+	return element.Value.(*cacheEntry).data, true
+}
+
+// Adds the data of a block to the cache, which must not be modified
+// afterwards. Blocks larger than the cache's capacity aren't cached.
+func (c *Cache) Add(key CacheKey, data []byte) {
+	if int64(len(data)) > c.capacity {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	c.entries[key] = c.recent.PushFront(&cacheEntry{key, data})
+	c.size += int64(len(data))
+
+	for c.size > c.capacity {
+		oldest := c.recent.Remove(c.recent.Back()).(*cacheEntry)
+		delete(c.entries, oldest.key)
+		c.size -= int64(len(oldest.data))
+	}
+}
+
+// Returns how well the cache has performed, and what it holds.
+func (c *Cache) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return CacheStats{
+		Hits:   c.hits,
+		Misses: c.misses,
+		Blocks: c.recent.Len(),
+		Bytes:  c.size,
+	}
+}
@@ -0,0 +1,98 @@
+package blocks
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(10)
+
+	c.Add(CacheKey{1, 0}, []byte("abcd"))
+	c.Add(CacheKey{1, 4}, []byte("efgh"))
+	c.Add(CacheKey{2, 0}, []byte("ij"))
+
+	// Too large to ever be cached.
+	c.Add(CacheKey{2, 2}, []byte("klmnopqrstu"))
+
+	if data, ok := c.Get(CacheKey{1, 0}); !ok || string(data) != "abcd" {
+		t.Errorf("Wrong cached block: want: abcd,true got: %s,%v", data, ok)
+	}
+
+	// Evicts the least recently used block.
+	c.Add(CacheKey{2, 4}, []byte("vw"))
+
+	var tests = []struct {
+		key  CacheKey
+		data string
+		ok   bool
+	}{
+		{CacheKey{1, 0}, "abcd", true},
+		{CacheKey{1, 4}, "", false},
+		{CacheKey{2, 0}, "ij", true},
+		{CacheKey{2, 2}, "", false},
+		{CacheKey{2, 4}, "vw", true},
+	}
+
+	for i, test := range tests {
+		if data, ok := c.Get(test.key); ok != test.ok || string(data) != test.data {
+			t.Errorf("Case %d: wrong cached block: want: %s,%v got: %s,%v", i, test.data, test.ok, data, ok)
+		}
+	}
+
+	want := CacheStats{Hits: 4, Misses: 2, Blocks: 3, Bytes: 8}
+
+	if stats := c.Stats(); !reflect.DeepEqual(stats, want) {
+		t.Errorf("Wrong cache stats:\n want: %+v\n  got: %+v", want, stats)
+	}
+}
+
+func TestCachedReader(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	w := NewChecksumWriter(buffer, 5)
+
+	w.Write([]byte("abcdefghijklmnopqrstuvwxyz"))
+	w.Flush()
+
+	shared := bytes.NewReader(buffer.Bytes())
+	cache := NewCache(1024)
+
+	for i := 0; i < 2; i++ {
+		r := NewCachedReaderAt(shared, 5, cache, 1)
+
+		if result, err := ioutil.ReadAll(r); string(result) != "abcdefghijklmnopqrstuvwxyz" || err != nil {
+			t.Errorf("Read %d: wrong result: want: abcdefghijklmnopqrstuvwxyz,<nil> got: %s,%v", i, result, err)
+		}
+	}
+
+	if stats := cache.Stats(); stats.Hits != 6 || stats.Misses != 6 || stats.Blocks != 6 {
+		t.Errorf("Wrong cache stats: want 6 hits, 6 misses and 6 blocks, got: %+v", stats)
+	}
+
+	// Seeking to a cached block and reading past it.
+	r := NewCachedReaderAt(shared, 5, cache, 1)
+	r.Seek(int64(headerLen(5)+5+checksumLen), 0)
+
+	if result, err := ioutil.ReadAll(r); string(result) != "fghijklmnopqrstuvwxyz" || err != nil {
+		t.Errorf("Wrong result after seek: want: fghijklmnopqrstuvwxyz,<nil> got: %s,%v", result, err)
+	}
+
+	// Cached blocks beyond the end of the reader aren't read.
+	bounded := io.NewSectionReader(shared, 0, int64(2*(headerLen(5)+5+checksumLen)))
+	r = NewCachedReaderAt(bounded, 5, cache, 1)
+
+	if result, err := ioutil.ReadAll(r); string(result) != "abcdefghij" || err != nil {
+		t.Errorf("Wrong result of bounded reader: want: abcdefghij,<nil> got: %s,%v", result, err)
+	}
+
+	// Blocks of other files aren't found.
+	r = NewCachedReaderAt(shared, 5, cache, 2)
+	ioutil.ReadAll(r)
+
+	if stats := cache.Stats(); stats.Blocks != 12 {
+		t.Errorf("Wrong cached blocks: want: 12 got: %d", stats.Blocks)
+	}
+}
@@ -0,0 +1,178 @@
+package blocks
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Encodings from CUSTOM_ENCODING up to (but not including)
+// CHECKSUM_FLAG are free for codecs registered by applications.
+// Those below it are reserved for codecs of this package.
+const CUSTOM_ENCODING = 16
+
+var ErrUnknownEncoding = errors.New("blocks: unknown block encoding")
+
+// A Codec compresses the data of blocks.
+//
+// Each block records the encoding of the codec it was compressed
+// with, and is decompressed with the codec registered for that
+// encoding, so settings which only affect compression, such as the
+// compression level, needn't be known when reading.
+type Codec interface {
+	// Identifies the codec in the encoding byte of each block.
+	Encoding() int
+
+	// Compresses src, returning the compressed data.
+	Encode(src []byte) ([]byte, error)
+
+	// Decompresses src, reusing dst if it's large enough.
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+var (
+	// Stores blocks uncompressed.
+	NoCompression Codec = noCompression{}
+
+	// Compresses blocks with snappy, which is fast, and
+	// the codec used by writers unless told otherwise.
+	Snappy Codec = snappyCodec{}
+)
+
+var (
+	codecs = map[int]Codec{
+		NO_COMPRESSION:     NoCompression,
+		SNAPPY_COMPRESSION: Snappy,
+		FLATE_COMPRESSION:  Flate(flate.DefaultCompression),
+	}
+
+	codecsLock sync.RWMutex
+)
+
+// Registers a codec, so blocks with its encoding can be read.
+// Its encoding must be at least CUSTOM_ENCODING, and less than
+// CHECKSUM_FLAG, and not already be registered.
+func RegisterCodec(codec Codec) error {
+	encoding := codec.Encoding()
+
+	if encoding < CUSTOM_ENCODING || encoding >= CHECKSUM_FLAG {
+		return fmt.Errorf("blocks: codec encoding %d is outside of the custom encodings", encoding)
+	}
+
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	if _, ok := codecs[encoding]; ok {
+		return fmt.Errorf("blocks: a codec with encoding %d is already registered", encoding)
+	}
+
+	codecs[encoding] = codec
+
+	return nil
+}
+
+// Returns the codec registered for the encoding.
+func LookupCodec(encoding int) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	codec, ok := codecs[encoding]
+	return codec, ok
+}
+
+type noCompression struct{}
+
+func (noCompression) Encoding() int { return NO_COMPRESSION }
+
+func (noCompression) Encode(src []byte) ([]byte, error) {
+	return src, nil
+}
+
+func (noCompression) Decode(dst, src []byte) ([]byte, error) {
+	return src, nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Encoding() int { return SNAPPY_COMPRESSION }
+
+func (snappyCodec) Encode(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCodec) Decode(dst, src []byte) ([]byte, error) {
+	return snappy.Decode(dst, src)
+}
+
+// Compresses blocks with DEFLATE, which is slower than snappy, but
+// compresses far better, particularly at higher levels. The level
+// is any of those accepted by compress/flate, and Flate panics if
+// it isn't, as every block would otherwise be left uncompressed.
+func Flate(level int) Codec {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		panic(fmt.Sprintf("blocks: invalid flate compression level %d", level))
+	}
+
+	return &flateCodec{level: level}
+}
+
+type flateCodec struct {
+	level int
+
+	// Flate writers are expensive to create, so they're reused.
+	writers sync.Pool
+}
+
+var flateReaders sync.Pool
+
+func (c *flateCodec) Encoding() int { return FLATE_COMPRESSION }
+
+func (c *flateCodec) Encode(src []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	w, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(buf)
+	} else {
+		var err error
+		if w, err = flate.NewWriter(buf, c.level); err != nil {
+			return nil, err
+		}
+	}
+
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *flateCodec) Decode(dst, src []byte) ([]byte, error) {
+	r, ok := flateReaders.Get().(io.ReadCloser)
+	if ok {
+		r.(flate.Resetter).Reset(bytes.NewReader(src), nil)
+	} else {
+		r = flate.NewReader(bytes.NewReader(src))
+	}
+
+	defer flateReaders.Put(r)
+
+	buf := bytes.NewBuffer(dst[:0])
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
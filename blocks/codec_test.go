@@ -0,0 +1,156 @@
+package blocks
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Reverses each block's bytes.
+type reverseCodec struct{}
+
+func (reverseCodec) Encoding() int { return CUSTOM_ENCODING + 1 }
+
+func (reverseCodec) Encode(src []byte) ([]byte, error) {
+	encoded := make([]byte, len(src))
+
+	for i, b := range src {
+		encoded[len(src)-1-i] = b
+	}
+
+	return encoded, nil
+}
+
+func (c reverseCodec) Decode(dst, src []byte) ([]byte, error) {
+	return c.Encode(src)
+}
+
+func init() {
+	if err := RegisterCodec(reverseCodec{}); err != nil {
+		panic(err)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	input := strings.Repeat(`{"url":"http://mysite.com/","visitor":"helloworld"}`, 500)
+
+	var tests = []struct {
+		codec    Codec
+		encoding int
+	}{
+		{NoCompression, NO_COMPRESSION},
+		{Snappy, SNAPPY_COMPRESSION},
+		{Flate(flate.BestSpeed), FLATE_COMPRESSION},
+		{Flate(flate.BestCompression), FLATE_COMPRESSION},
+		{reverseCodec{}, CUSTOM_ENCODING + 1},
+	}
+
+	for i, test := range tests {
+		for _, checksums := range []bool{false, true} {
+			buffer := new(bytes.Buffer)
+			w := NewWriterWithOptions(buffer, 4096, WriterOptions{Codec: test.codec, Checksums: checksums})
+
+			w.Write([]byte(input))
+			w.Flush()
+
+			if encoding := int(buffer.Bytes()[2]) &^ CHECKSUM_FLAG; encoding != test.encoding {
+				t.Errorf("Case %d: wrong block encoding: want: %d got: %d", i, test.encoding, encoding)
+			}
+
+			r := NewReader(bytes.NewReader(buffer.Bytes()), 4096)
+
+			if result, err := ioutil.ReadAll(r); string(result) != input || err != nil {
+				t.Errorf("Case %d: wrong read of blocks: want: %d bytes,<nil> got: %d bytes,%v", i, len(input), len(result), err)
+			}
+
+			fr := NewFastReader(context.Background(), bytes.NewReader(buffer.Bytes()), 4096)
+
+			if result, err := ioutil.ReadAll(fr); string(result) != input || err != nil {
+				t.Errorf("Case %d: wrong fast read of blocks: want: %d bytes,<nil> got: %d bytes,%v", i, len(input), len(result), err)
+			}
+		}
+	}
+}
+
+func TestFlateCompressesBetter(t *testing.T) {
+	input := []byte(strings.Repeat(`{"url":"http://mysite.com/","visitor":"helloworld"}`, 500))
+
+	snappied, flated := new(bytes.Buffer), new(bytes.Buffer)
+
+	for _, test := range []struct {
+		buffer *bytes.Buffer
+		codec  Codec
+	}{{snappied, Snappy}, {flated, Flate(flate.BestCompression)}} {
+		w := NewWriterWithOptions(test.buffer, 4096, WriterOptions{Codec: test.codec})
+		w.Write(input)
+		w.Flush()
+	}
+
+	if flated.Len() >= snappied.Len() {
+		t.Errorf("Expected flate to compress better than snappy: flate: %d snappy: %d", flated.Len(), snappied.Len())
+	}
+}
+
+func TestFlateLevels(t *testing.T) {
+	for _, level := range []int{flate.HuffmanOnly, flate.NoCompression, flate.BestCompression} {
+		if encoded, err := Flate(level).Encode([]byte("aaaa")); err != nil || len(encoded) == 0 {
+			t.Errorf("Level %d: failed to encode: %v", level, err)
+		}
+	}
+
+	for _, level := range []int{flate.HuffmanOnly - 1, flate.BestCompression + 1, 42} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Level %d: expected invalid level to panic", level)
+				}
+			}()
+
+			Flate(level)
+		}()
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	var tests = []Codec{
+		Snappy,
+		Flate(flate.DefaultCompression),
+		reverseCodec{},
+		codecWithEncoding(CHECKSUM_FLAG),
+		codecWithEncoding(CUSTOM_ENCODING - 1),
+	}
+
+	for i, codec := range tests {
+		if err := RegisterCodec(codec); err == nil {
+			t.Errorf("Case %d: expected error registering codec with encoding %d", i, codec.Encoding())
+		}
+	}
+
+	if codec, ok := LookupCodec(CUSTOM_ENCODING + 1); !ok || codec != (reverseCodec{}) {
+		t.Errorf("Wrong codec found for custom encoding: %v", codec)
+	}
+
+	if _, ok := LookupCodec(CUSTOM_ENCODING + 2); ok {
+		t.Errorf("Found codec for unregistered encoding")
+	}
+}
+
+type codecWithEncoding int
+
+func (c codecWithEncoding) Encoding() int                          { return int(c) }
+func (c codecWithEncoding) Encode(src []byte) ([]byte, error)      { return src, nil }
+func (c codecWithEncoding) Decode(dst, src []byte) ([]byte, error) { return src, nil }
+
+func TestReadUnknownEncoding(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte("\x03\x00\x7f\xff\xff\xff")), 32)
+
+	_, err := r.ReadByte()
+
+	if corrupt, ok := err.(*ErrCorruptBlock); !ok || !errors.Is(corrupt.Err, ErrUnknownEncoding) {
+		t.Errorf("Wrong error for block with unknown encoding: %v", err)
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"encoding/binary"
 	"io"
 	"sync"
-
-	"github.com/golang/snappy"
 )
 
 type read struct {
@@ -23,7 +21,9 @@ type FastReader struct {
 	scratch       *bytes.Buffer
 	reads         chan read
 	readErr       error
+	blockErr      error
 	reader        io.Reader
+	offset        int64
 	blockSize     int
 	snapbuf       []byte
 	headerLen     int
@@ -120,12 +120,25 @@ func (r *FastReader) ReadByte() (c byte, err error) {
 // A following call to Read will return the same bytes.
 func (r *FastReader) Peek(n int) []byte {
 	r.ensure(n)
+
+	// If we've reached the end of the data, there
+	// might be fewer than n bytes available.
+	if b := r.buffer.Bytes(); len(b) < n {
+		return b
+	}
+
 	return r.buffer.Bytes()[:n]
 }
 
 // Ensure the buffer contains at least `length` bytes
 func (r *FastReader) ensure(length int) (err error) {
 	for r.buffer.Len() < length {
+		// Once a block couldn't be decoded, we no longer know
+		// where the next one begins, so keep returning the error.
+		if r.blockErr != nil {
+			return r.blockErr
+		}
+
 		err = r.fetchBlock()
 
 		if err == io.EOF {
@@ -133,6 +146,7 @@ func (r *FastReader) ensure(length int) (err error) {
 		}
 
 		if err != nil {
+			r.blockErr = err
 			return
 		}
 	}
@@ -148,26 +162,36 @@ func (r *FastReader) fetchBlock() (err error) {
 		return
 	}
 
+	offset := r.offset
+
 	head := r.scratch.Next(r.headerLen)
 	length, encoding := r.parseHeader(head)
+	r.offset += int64(r.headerLen)
 	if length == 0 {
 		return
 	}
 
-	err = r.ensureScratch(length)
+	// Checksummed blocks are followed by their checksum.
+	size := length
+	if encoding&CHECKSUM_FLAG != 0 {
+		size += checksumLen
+	}
+
+	err = r.ensureScratch(size)
 	if err != nil {
 		return
 	}
 
-	body := r.scratch.Next(int(length))
-	if encoding == SNAPPY_COMPRESSION {
-		body, _ := snappy.Decode(r.snapbuf, body)
-		r.snapbuf = body
-		r.buffer.Write(body)
-	} else {
-		r.buffer.Write(body)
+	raw := r.scratch.Next(int(size))
+	r.offset += int64(size)
+
+	body, err := decodeBlock(raw, encoding, &r.snapbuf, offset)
+	if err != nil {
+		return
 	}
 
+	r.buffer.Write(body)
+
 	return
 }
 
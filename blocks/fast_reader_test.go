@@ -190,3 +190,33 @@ func TestFastReadByte(t *testing.T) {
 		t.Errorf("Wrong return from empty ReadByte: want: <nil>,EOF got: %x%v", b, err)
 	}
 }
+
+func TestFastReadChecksums(t *testing.T) {
+	input := strings.Repeat("helloworld", 10)
+
+	buffer := new(bytes.Buffer)
+	w := NewChecksumWriter(buffer, 32)
+
+	w.Write([]byte(input))
+	w.Flush()
+
+	r := NewFastReader(context.Background(), bytes.NewReader(buffer.Bytes()), 32)
+	defer r.Close()
+
+	result := make([]byte, len(input))
+	if n, err := io.ReadFull(r, result); string(result) != input || err != nil {
+		t.Errorf("Wrong read of checksummed blocks: want: %q,<nil> got: %q,%d,%v", input, result, n, err)
+	}
+
+	corrupt := append([]byte{}, buffer.Bytes()...)
+	corrupt[4] ^= 0x01
+
+	cr := NewFastReader(context.Background(), bytes.NewReader(corrupt), 32)
+	defer cr.Close()
+
+	if _, err := cr.ReadByte(); err == nil {
+		t.Errorf("Expected error for corrupted block")
+	} else if mismatch, ok := err.(*ErrChecksumMismatch); !ok || mismatch.Offset != 0 {
+		t.Errorf("Wrong error for corrupted block: want: checksum mismatch at 0 got: %v", err)
+	}
+}
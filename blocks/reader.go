@@ -4,23 +4,35 @@ import (
 	"bytes"
 	"errors"
 	"io"
-
-	"github.com/golang/snappy"
+	"math"
 )
 
 var BadSeek = errors.New("block reader can only seek relative to beginning of file.")
 
+// Readers of blocks larger than this start with buffers of this size.
+const initialBufferSize = 4096
+
 // Reader has the ability to uncompress and read any potentially compressed
 // data written via blocks.Writer.
 type Reader struct {
-	buffer      *bytes.Buffer
+	buffer      []byte
+	spare       []byte
 	scratch     *bytes.Buffer
 	reader      io.Reader
+	data        []byte
 	blockSize   int
 	headerLen   int
 	parseHeader func(head []byte) (size uint, encoding int)
 	block       []byte
 	snapbuf     []byte
+	offset      int64
+	current     int64
+	currentLen  int
+	fetched     bool
+	stable      bool
+	err         error
+	cache       *Cache
+	file        uint64
 }
 
 // Transforms a bytestring into a block reader. blockSize must be the same size
@@ -51,18 +63,55 @@ func NewReader(r io.Reader, blockSize int) *Reader {
 		headerLen = 9 // uint64 + byte
 	}
 
+	// Buffers for large blocks are grown as blocks are read, so
+	// readers which only read a little, such as those of small
+	// sections, stay small.
+	initial := blockSize
+	if initial > initialBufferSize {
+		initial = initialBufferSize
+	}
+
 	return &Reader{
-		buffer:      new(bytes.Buffer),
 		scratch:     new(bytes.Buffer),
 		reader:      r,
 		blockSize:   blockSize,
 		headerLen:   headerLen,
 		parseHeader: parseHeader,
-		block:       make([]byte, headerLen+blockSize),
-		snapbuf:     make([]byte, 2*blockSize),
+		block:       make([]byte, headerLen+initial),
+		snapbuf:     make([]byte, 2*initial),
 	}
 }
 
+// Transforms any object which implements io.ReaderAt into a block reader.
+// The reader keeps track of its own position and only ever uses positional
+// reads, so any number of block readers can share the same io.ReaderAt
+// (such as an *os.File) from multiple goroutines.
+func NewReaderAt(r io.ReaderAt, blockSize int) *Reader {
+	return NewReader(io.NewSectionReader(r, 0, math.MaxInt64), blockSize)
+}
+
+// Like NewReaderAt, but blocks are looked up in the cache before
+// they're read from r, and added to it after, identified by file
+// and their offset within r.
+func NewCachedReaderAt(r io.ReaderAt, blockSize int, cache *Cache, file uint64) *Reader {
+	reader := NewReaderAt(r, blockSize)
+	reader.cache = cache
+	reader.file = file
+	return reader
+}
+
+// Transforms a bytestring into a block reader, like NewByteReader,
+// but blocks are read from b directly rather than copied, and the
+// data of uncompressed blocks is returned by ReadSlice without
+// copying, so b mustn't be modified while it's being read. As b is
+// never modified, it can be read-only memory, such as a mapped file,
+// and shared by any number of readers.
+func NewSliceReader(b []byte, blockSize int) *Reader {
+	reader := NewByteReader(b, blockSize)
+	reader.data = b
+	return reader
+}
+
 // Implements io.Reader interface.
 func (r *Reader) Read(p []byte) (n int, err error) {
 	err = r.ensure(len(p))
@@ -70,7 +119,14 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 		return
 	}
 
-	return r.buffer.Read(p)
+	if len(r.buffer) == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+
+	n = copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+
+	return
 }
 
 // Implements io.ByteReader interface.
@@ -80,10 +136,39 @@ func (r *Reader) ReadByte() (c byte, err error) {
 		return
 	}
 
-	b := make([]byte, 1)
+	if len(r.buffer) == 0 {
+		return 0, io.EOF
+	}
+
+	c = r.buffer[0]
+	r.buffer = r.buffer[1:]
+
+	return
+}
+
+// Reads the next n bytes, like io.ReadFull, but the returned slice
+// may share memory with the reader, which is only safe to keep
+// once the reader has moved on when it's stable. Slices are stable
+// when they're from uncompressed blocks read by a NewSliceReader, as
+// they're then slices of the bytestring being read.
+func (r *Reader) ReadSlice(n int) (b []byte, stable bool, err error) {
+	if err = r.ensure(n); err != nil {
+		return
+	}
+
+	if len(r.buffer) < n {
+		if len(r.buffer) == 0 {
+			err = io.EOF
+		} else {
+			err = io.ErrUnexpectedEOF
+		}
+
+		r.buffer = r.buffer[len(r.buffer):]
+		return
+	}
 
-	_, err = r.buffer.Read(b)
-	c = b[0]
+	b, stable = r.buffer[:n:n], r.stable
+	r.buffer = r.buffer[n:]
 
 	return
 }
@@ -92,7 +177,26 @@ func (r *Reader) ReadByte() (c byte, err error) {
 // A following call to Read will return the same bytes.
 func (r *Reader) Peek(n int) []byte {
 	r.ensure(n)
-	return r.buffer.Bytes()[:n]
+
+	// If we've reached the end of the data, there
+	// might be fewer than n bytes available.
+	if len(r.buffer) < n {
+		return r.buffer
+	}
+
+	return r.buffer[:n]
+}
+
+// Returns the location of the next byte to be read: the offset of
+// the block it's in within the underlying reader, and its offset
+// within the block's uncompressed data. This matches the location
+// a blocks.Writer reports via Written and Buffered when writing it.
+func (r *Reader) Position() (block int64, offset int) {
+	if !r.fetched {
+		return r.offset, 0
+	}
+
+	return r.current, r.currentLen - len(r.buffer)
 }
 
 // Implements io.Seeker interface. One limitiation we have is
@@ -104,14 +208,24 @@ func (r *Reader) Seek(offset int64, whence int) (int64, error) {
 		return 0, BadSeek
 	}
 
-	r.buffer = new(bytes.Buffer)
+	r.buffer = nil
 	r.scratch = new(bytes.Buffer)
+	r.offset = offset
+	r.current = offset
+	r.fetched = false
+	r.err = nil
 	return seeker.Seek(offset, 0)
 }
 
 // Ensure the buffer contains at least `length` bytes
 func (r *Reader) ensure(length int) (err error) {
-	for r.buffer.Len() < length {
+	for len(r.buffer) < length {
+		// Once a block couldn't be read, we no longer know where
+		// the next one begins, so keep returning the same error.
+		if r.err != nil {
+			return r.err
+		}
+
 		err = r.fetchBlock()
 
 		if err == io.EOF {
@@ -119,6 +233,7 @@ func (r *Reader) ensure(length int) (err error) {
 		}
 
 		if err != nil {
+			r.err = err
 			return
 		}
 	}
@@ -129,45 +244,153 @@ func (r *Reader) ensure(length int) (err error) {
 // Fetches the next block from the underlying reader,
 // optionally decompresses it, and adds it to the buffer.
 func (r *Reader) fetchBlock() (err error) {
-	err = r.ensureScratch(uint(r.headerLen))
+	head, err := r.readRaw(uint(r.headerLen))
 	if err != nil {
 		return
 	}
 
-	head := r.scratch.Next(r.headerLen)
+	r.current = r.offset
+
 	length, encoding := r.parseHeader(head)
+	r.offset += int64(r.headerLen)
 	if length == 0 {
 		return
 	}
 
-	err = r.ensureScratch(length)
+	// Checksummed blocks are followed by their checksum.
+	size := length
+	if encoding&CHECKSUM_FLAG != 0 {
+		size += checksumLen
+	}
+
+	// The block's header is always read, so cached
+	// blocks are never read beyond the end of r.
+	key := CacheKey{r.file, r.current}
+
+	if r.cache != nil {
+		if body, ok := r.cache.Get(key); ok {
+			if err = r.skipRaw(size); err != nil {
+				return
+			}
+
+			// Cached bodies are shared by every reader of the
+			// cache, so slices of them mustn't be handed out.
+			r.offset += int64(size)
+			r.buffer = r.append(body, false)
+			r.currentLen = len(body)
+			r.fetched = true
+
+			return
+		}
+	}
+
+	raw, err := r.readRaw(size)
+	if err != nil {
+		return
+	}
+
+	r.offset += int64(size)
+
+	body, err := decodeBlock(raw, encoding, &r.snapbuf, r.current)
 	if err != nil {
 		return
 	}
 
-	body := r.scratch.Next(int(length))
-	if encoding == SNAPPY_COMPRESSION {
-		body, _ := snappy.Decode(r.snapbuf, body)
-		r.snapbuf = body
-		r.buffer.Write(body)
-	} else {
-		r.buffer.Write(body)
+	// The body may be in a buffer which is reused.
+	if r.cache != nil {
+		r.cache.Add(key, append([]byte(nil), body...))
 	}
 
+	// Uncompressed blocks read from a bytestring
+	// are part of it, so needn't be copied.
+	stable := r.data != nil && encoding&^CHECKSUM_FLAG == NO_COMPRESSION
+
+	r.buffer = r.append(body, stable)
+	r.currentLen = len(body)
+	r.fetched = true
+
 	return
 }
 
+// Returns the buffered data followed by the body of a block. Stable
+// bodies, which are never modified, are used without copying when
+// there's no buffered data.
+func (r *Reader) append(body []byte, stable bool) []byte {
+	if len(r.buffer) == 0 && stable {
+		r.stable = true
+		return body
+	}
+
+	// The buffered data may be within the spare buffer,
+	// which copy, and so append, handle correctly.
+	r.spare = append(append(r.spare[:0], r.buffer...), body...)
+	r.stable = false
+
+	return r.spare
+}
+
+// Reads the next length bytes of raw data.
+func (r *Reader) readRaw(length uint) ([]byte, error) {
+	if r.data != nil {
+		if r.offset+int64(length) > int64(len(r.data)) {
+			return nil, io.EOF
+		}
+
+		return r.data[r.offset : r.offset+int64(length)], nil
+	}
+
+	if err := r.ensureScratch(length); err != nil {
+		return nil, err
+	}
+
+	return r.scratch.Next(int(length)), nil
+}
+
+// Skips past length bytes of raw data, without
+// reading them from the underlying reader if possible.
+func (r *Reader) skipRaw(length uint) error {
+	if r.data != nil {
+		return nil
+	}
+
+	if remaining := int64(length) - int64(r.scratch.Len()); remaining > 0 {
+		if seeker, ok := r.reader.(io.Seeker); ok {
+			r.scratch.Reset()
+			_, err := seeker.Seek(remaining, io.SeekCurrent)
+			return err
+		}
+	}
+
+	if err := r.ensureScratch(length); err != nil {
+		return err
+	}
+
+	r.scratch.Next(int(length))
+	return nil
+}
+
 // Ensure the raw scratch space contains at least `length` bytes.
 func (r *Reader) ensureScratch(length uint) (err error) {
 	var n int
 
+	// Read whole blocks at once, up to the largest block.
+	if need := int(length) - r.scratch.Len(); need > len(r.block) && len(r.block) < r.headerLen+r.blockSize+checksumLen {
+		if need > r.headerLen+r.blockSize+checksumLen {
+			need = r.headerLen + r.blockSize + checksumLen
+		}
+
+		r.block = make([]byte, need)
+	}
+
 	for uint(r.scratch.Len()) < length {
+		// Readers such as io.SectionReader may return data
+		// along with io.EOF, so keep what was read first.
 		n, err = r.reader.Read(r.block)
-		if err != nil {
+		r.scratch.Write(r.block[:n])
+		if err != nil && uint(r.scratch.Len()) < length {
 			return
 		}
-		r.scratch.Write(r.block[:n])
 	}
 
-	return
+	return nil
 }
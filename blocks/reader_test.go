@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -140,3 +142,142 @@ func TestSeek(t *testing.T) {
 		t.Errorf("Wrong return:\n want: 0,block reader can only seek relative to beginning of file.\n  got: %d,%v", n, err)
 	}
 }
+
+func TestReaderAt(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	w := NewWriter(buffer, 5)
+
+	w.Write([]byte("abcdefghijklmnopqrstuvwxyz"))
+	w.Flush()
+
+	shared := bytes.NewReader(buffer.Bytes())
+
+	r1 := NewReaderAt(shared, 5)
+	r2 := NewReaderAt(shared, 5)
+
+	r1.Seek(8, 0)
+	r2.Seek(16, 0)
+
+	var tests = []struct {
+		reader *Reader
+		result string
+	}{
+		{r1, "fg"},
+		{r2, "kl"},
+		{r1, "hijk"},
+		{r2, "mnopq"},
+	}
+
+	for i, test := range tests {
+		bytes := make([]byte, len(test.result))
+		test.reader.Read(bytes)
+
+		if !reflect.DeepEqual(bytes, []byte(test.result)) {
+			t.Errorf("Wrong bytes for Case %d:\n want: %s\n  got: %s", i, test.result, bytes)
+		}
+	}
+}
+
+func TestReadChecksums(t *testing.T) {
+	input := strings.Repeat("helloworld", 10)
+
+	buffer := new(bytes.Buffer)
+	w := NewChecksumWriter(buffer, 32)
+
+	w.Write([]byte(input))
+	w.Flush()
+
+	r := NewReader(bytes.NewReader(buffer.Bytes()), 32)
+
+	if result, err := ioutil.ReadAll(r); string(result) != input || err != nil {
+		t.Errorf("Wrong read of checksummed blocks: want: %q,<nil> got: %q,%v", input, result, err)
+	}
+
+	// Flip a bit in the second block's data. The first block
+	// is snappy compressed, so its length varies.
+	second := 3 + int(buffer.Bytes()[0]) + 4
+	corrupt := append([]byte{}, buffer.Bytes()...)
+	corrupt[second+5] ^= 0x01
+
+	r = NewReader(bytes.NewReader(corrupt), 32)
+
+	block := make([]byte, 32)
+	if n, err := r.Read(block); n != 32 || err != nil {
+		t.Errorf("Wrong read of first block: want: 32,<nil> got: %d,%v", n, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := r.Read(block)
+
+		if mismatch, ok := err.(*ErrChecksumMismatch); !ok || mismatch.Offset != int64(second) {
+			t.Errorf("Wrong error for corrupted block: want: checksum mismatch at %d got: %v", second, err)
+		}
+	}
+}
+
+func TestReadCorruptSnappy(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte("\x03\x00\x01\xff\xff\xff")), 32)
+
+	if _, err := r.ReadByte(); err == nil {
+		t.Errorf("Expected error for corrupted snappy block")
+	} else if _, ok := err.(*ErrCorruptBlock); !ok {
+		t.Errorf("Wrong error for corrupted snappy block: %v", err)
+	}
+}
+
+func TestSliceReader(t *testing.T) {
+	input := strings.Repeat("helloworld", 100)
+
+	var tests = []struct {
+		codec  Codec
+		stable bool
+	}{
+		{NoCompression, true},
+		{Snappy, false},
+	}
+
+	for i, test := range tests {
+		buffer := new(bytes.Buffer)
+		w := NewWriterWithOptions(buffer, 200, WriterOptions{Codec: test.codec, Checksums: true})
+
+		w.Write([]byte(input))
+		w.Flush()
+
+		data := buffer.Bytes()
+		r := NewSliceReader(data, 200)
+		result := make([]byte, 0)
+
+		for {
+			b, stable, err := r.ReadSlice(200)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Case %d: failed read: %v", i, err)
+			}
+
+			// Stable slices are part of the data being read.
+			within := false
+			for j := range data {
+				within = within || &data[j] == &b[0]
+			}
+
+			if stable != test.stable || within != test.stable {
+				t.Errorf("Case %d: wrong stability: want: %v got: %v,%v", i, test.stable, stable, within)
+			}
+
+			result = append(result, b...)
+		}
+
+		if string(result) != input {
+			t.Errorf("Case %d: wrong bytes:\n want: %s\n  got: %s", i, input, result)
+		}
+
+		// Slices spanning blocks are copied.
+		r = NewSliceReader(data, 200)
+		r.ReadSlice(10)
+
+		if b, stable, err := r.ReadSlice(200); string(b) != strings.Repeat("helloworld", 20) || stable || err != nil {
+			t.Errorf("Case %d: wrong slice spanning blocks: got: %s,%v,%v", i, b, stable, err)
+		}
+	}
+}
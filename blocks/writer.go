@@ -4,15 +4,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
-
-	"github.com/golang/snappy"
 )
 
 // Writer implements the io.Writer interface and is meant to be
 // used in place of a io.Writer or bufio.Writer when writing data.
 //
 // Data is written in blocks or chunks, and optionally
-// compressed with snappy compression.
+// compressed, with snappy compression unless another
+// Codec is chosen.
 //
 // When data is written, if the buffered amount then exceeds the
 // configured blockSize, the block is encoded and compressed and
@@ -27,18 +26,53 @@ import (
 // can contain the max configured blockSize.  For instance,
 // if blockSize is 4096 bytes, we'll used an uint16. If the blockSize
 // is 128 KB, we'll use a uint32, etc.
+//
+// Writers created with NewChecksumWriter set CHECKSUM_FLAG on the
+// encoding, and follow each block's data with a checksum:
+//
+//     [int16/int32/int64:blockLength][int8:encoding][bytes(blockLength):data][uint32:crc32c]
+//
+// The checksum is the CRC32C (Castagnoli) of the block's data followed
+// by its encoding byte. blockLength doesn't include the checksum.
 type Writer struct {
 	buffer    *bytes.Buffer
 	writer    io.Writer
 	Written   int
 	Blocks    int
 	blockSize int
+	checksum  bool
+	codec     Codec
+}
+
+type WriterOptions struct {
+	// Compresses each block. Defaults to Snappy.
+	Codec Codec
+
+	// Stores a checksum with each block which readers verify,
+	// so corrupted blocks are detected when read.
+	Checksums bool
 }
 
 // Tranforms any io.Writer into a block writer using the
 // configured max blockSize.
 func NewWriter(w io.Writer, blockSize int) *Writer {
-	return &Writer{new(bytes.Buffer), w, 0, 0, blockSize}
+	return NewWriterWithOptions(w, blockSize, WriterOptions{})
+}
+
+// Like NewWriter, but stores a checksum with each block which
+// readers verify, so corrupted blocks are detected when read.
+func NewChecksumWriter(w io.Writer, blockSize int) *Writer {
+	return NewWriterWithOptions(w, blockSize, WriterOptions{Checksums: true})
+}
+
+// Like NewWriter, but configured with the given options.
+func NewWriterWithOptions(w io.Writer, blockSize int, options WriterOptions) *Writer {
+	codec := options.Codec
+	if codec == nil {
+		codec = Snappy
+	}
+
+	return &Writer{new(bytes.Buffer), w, 0, 0, blockSize, options.Checksums, codec}
 }
 
 // Implements io.Writer interface.
@@ -78,9 +112,15 @@ func (w *Writer) flush(size int) (n int, err error) {
 
 		// Data is only encoded if we successfully encode the block.
 		// Otherwise the block is identified as uncompressed.
-		if encoded := snappy.Encode(nil, block); len(encoded) <= len(block) {
-			encoding = SNAPPY_COMPRESSION
-			block = encoded
+		if w.codec.Encoding() != NO_COMPRESSION {
+			if encoded, err := w.codec.Encode(block); err == nil && len(encoded) <= len(block) {
+				encoding = w.codec.Encoding()
+				block = encoded
+			}
+		}
+
+		if w.checksum {
+			encoding |= CHECKSUM_FLAG
 		}
 
 		head := header(w.blockSize, encoding, block)
@@ -101,6 +141,19 @@ func (w *Writer) flush(size int) (n int, err error) {
 			return
 		}
 
+		if w.checksum {
+			trailer := make([]byte, checksumLen)
+			binary.LittleEndian.PutUint32(trailer, checksum(block, byte(encoding)))
+
+			i, err = w.writer.Write(trailer)
+			w.Written += i
+			n += i
+
+			if err != nil {
+				return
+			}
+		}
+
 		w.Blocks += 1
 	}
 
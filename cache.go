@@ -0,0 +1,63 @@
+package esdb
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/customerio/esdb/blocks"
+	"github.com/customerio/esdb/sst"
+)
+
+// Identifies each file opened with a block cache, so
+// their blocks are kept apart in the cache.
+var cachedFiles uint64
+
+// Where the blocks of a file are cached when they're read, which
+// are the blocks of events, indexes, and SSTables. A nil cache
+// doesn't cache anything.
+type blockCache struct {
+	cache *blocks.Cache
+	file  uint64
+}
+
+// Returns where a file's blocks are cached within the cache,
+// which may be shared with other files, or nil for no cache.
+func newBlockCache(cache *blocks.Cache) *blockCache {
+	if cache == nil {
+		return nil
+	}
+
+	return &blockCache{
+		cache: cache,
+		file:  atomic.AddUint64(&cachedFiles, 1),
+	}
+}
+
+// Returns a reader of the blocks of r, which must be the whole file.
+func (c *blockCache) newReader(r io.ReaderAt, blockSize int) *blocks.Reader {
+	if c == nil {
+		return blocks.NewReaderAt(r, blockSize)
+	}
+
+	return blocks.NewCachedReaderAt(r, blockSize, c.cache, c.file)
+}
+
+// Opens the SSTable found at the offset within r,
+// which must be the whole file.
+func (c *blockCache) newTableReader(r io.ReaderAt, offset, length int64) (*sst.Reader, error) {
+	section := io.NewSectionReader(r, offset, length)
+
+	if c == nil {
+		return sst.NewReader(section, length)
+	}
+
+	return sst.NewCachedReader(section, length, c.cache, c.file, offset)
+}
+
+func (c *blockCache) stats() blocks.CacheStats {
+	if c == nil {
+		return blocks.CacheStats{}
+	}
+
+	return c.cache.Stats()
+}
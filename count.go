@@ -0,0 +1,152 @@
+package esdb
+
+import (
+	"math"
+)
+
+// Returns the number of events in a grouping.
+func (s *Space) Count(grouping string) (int, error) {
+	return s.CountRange(grouping, math.MinInt64, math.MaxInt64)
+}
+
+// Returns the number of events in a grouping with timestamps
+// between from and to (inclusive).
+func (s *Space) CountRange(grouping string, from, to int) (int, error) {
+	return s.countSection("g"+grouping, from, to)
+}
+
+// Returns the number of events in an index.
+func (s *Space) CountIndex(name, value string) (int, error) {
+	return s.CountIndexRange(name, value, math.MinInt64, math.MaxInt64)
+}
+
+// Returns the number of events in an index with timestamps
+// between from and to (inclusive).
+func (s *Space) CountIndexRange(name, value string, from, to int) (int, error) {
+	return s.countSection("i"+name+":"+value, from, to)
+}
+
+// Counts the entries of a section within the range, without reading
+// any event data.
+//
+// The section's stats answer counts of the whole section, and its
+// summary records how many entries begin in each block. So only the
+// blocks which are partly within the range are read, and only for
+// the timestamps of their entries.
+func (s *Space) countSection(key string, from, to int) (int, error) {
+	sec, err := s.findSection(key)
+	if sec == nil {
+		return 0, err
+	}
+
+	if stats := sec.stats; stats != nil {
+		if from <= stats.MinTimestamp && to >= stats.MaxTimestamp {
+			return stats.Events, nil
+		}
+
+		if from > stats.MaxTimestamp || to < stats.MinTimestamp {
+			return 0, nil
+		}
+	}
+
+	sum, err := s.readSummary(sec)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without a summary, the whole section is read.
+	if sum == nil {
+		return s.countEntries(key, sec, summaryEntry{block: sec.offset, count: -1}, from, to)
+	}
+
+	count := 0
+
+	for i, entry := range sum {
+		// Sections are sorted newest first, so all
+		// of the following entries are older still.
+		if entry.timestamp < from {
+			break
+		}
+
+		// The entries beginning in the block are no older than the
+		// first entry of the next block, or the oldest of the section.
+		oldest, known := 0, false
+
+		if i+1 < len(sum) {
+			oldest, known = sum[i+1].timestamp, true
+		} else if sec.stats != nil {
+			oldest, known = sec.stats.MinTimestamp, true
+		}
+
+		if known && oldest > to {
+			continue
+		}
+
+		if known && oldest >= from && entry.timestamp <= to {
+			count += entry.count
+			continue
+		}
+
+		n, err := s.countEntries(key, sec, entry, from, to)
+		if err != nil {
+			return 0, err
+		}
+
+		count += n
+	}
+
+	return count, nil
+}
+
+// Counts the entries within the range of the given summary entry's
+// block, reading the timestamp of each. A negative count reads the
+// entries up until the end of the section.
+func (s *Space) countEntries(key string, sec *section, start summaryEntry, from, to int) (int, error) {
+	isIndex := key[0] == 'i'
+
+	reader := s.newSectionReader(sec)
+	if err := s.seekBlock(reader, start.block, int64(start.offset)); err != nil {
+		return 0, err
+	}
+
+	// Only needed for indexes without timestamps in their entries.
+	events := s.newReader()
+
+	count := 0
+
+	for i := 0; start.count < 0 || i < start.count; i++ {
+		var timestamp int
+
+		if isIndex {
+			entry, err := pullIndexEntry(reader, s.header)
+			if err != nil || entry == nil {
+				return count, err
+			}
+
+			if !s.header.indexTimestamps() {
+				if err = s.readEntryTimestamp(events, entry); err != nil {
+					return count, err
+				}
+			}
+
+			timestamp = entry.timestamp
+		} else {
+			var ok bool
+			var err error
+
+			if timestamp, ok, err = skipEvent(reader, s.header); err != nil || !ok {
+				return count, err
+			}
+		}
+
+		if timestamp < from {
+			break
+		}
+
+		if timestamp <= to {
+			count += 1
+		}
+	}
+
+	return count, nil
+}
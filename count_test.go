@@ -0,0 +1,56 @@
+package esdb
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSpaceCounting(t *testing.T) {
+	count := 3000
+
+	withoutTimestamps := currentHeader()
+	withoutTimestamps.flags &^= FLAG_INDEX_TIMESTAMPS
+
+	var ranges = [][2]int{
+		{math.MinInt64, math.MaxInt64},
+		{0, count},
+		{100, 300},
+		{101, 101},
+		{-10, 5},
+		{700, 1000},
+		{1000, 2000},
+		{300, 100},
+	}
+
+	for _, header := range []fileHeader{currentHeader(), withoutTimestamps, legacyHeader()} {
+		space := createQueried(header, count)
+
+		for _, bounds := range ranges {
+			from, to := bounds[0], bounds[1]
+
+			want := len(expectQueried(count, from, to, func(i int) bool { return true }))
+
+			if found, err := space.CountRange("g", from, to); err != nil || found != want {
+				t.Errorf("Flags %d (%d-%d): wrong grouping count: wanted: %d found: %d %v", header.flags, from, to, want, found, err)
+			}
+
+			want = len(expectQueried(count, from, to, func(i int) bool { return i%3 == 1 }))
+
+			if found, err := space.CountIndexRange("mod3", "1", from, to); err != nil || found != want {
+				t.Errorf("Flags %d (%d-%d): wrong index count: wanted: %d found: %d %v", header.flags, from, to, want, found, err)
+			}
+		}
+
+		if found, err := space.Count("g"); err != nil || found != count {
+			t.Errorf("Flags %d: wrong grouping count: wanted: %d found: %d %v", header.flags, count, found, err)
+		}
+
+		if found, err := space.CountIndex("mod2", "0"); err != nil || found != count/2 {
+			t.Errorf("Flags %d: wrong index count: wanted: %d found: %d %v", header.flags, count/2, found, err)
+		}
+
+		if found, err := space.CountIndex("mod2", "missing"); err != nil || found != 0 {
+			t.Errorf("Flags %d: wrong missing index count: wanted: 0 found: %d %v", header.flags, found, err)
+		}
+	}
+}
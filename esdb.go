@@ -2,38 +2,134 @@ package esdb
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"sync"
 
 	"github.com/customerio/esdb/binary"
-	"github.com/customerio/esdb/bounded"
+	"github.com/customerio/esdb/blocks"
 	"github.com/customerio/esdb/sst"
 )
 
-// TODO Verify(file string) bool
-
+// Db is safe to use from multiple goroutines, as are the
+// spaces it returns. All reads from the underlying file are
+// positional, so concurrent scans don't share any cursor.
 type Db struct {
 	file          *os.File
+	reader        io.ReaderAt
+	unmap         func() error
+	size          int64
+	header        fileHeader
 	index         *sst.Reader
+	cache         *blockCache
 	locations     map[string][]int64
 	calcLocations sync.Once
 }
 
+// OpenOptions configures how an ESDB file is read.
+type OpenOptions struct {
+	// The most bytes of blocks, after they're decompressed, to keep
+	// in memory, so blocks read again, such as by repeated queries
+	// of the same spaces, aren't read from the file and decompressed
+	// again. Blocks are cached across all spaces of the Db, whether
+	// they hold events, indexes, or the indexes of spaces. Zero, the
+	// default, doesn't cache any blocks.
+	BlockCacheBytes int64
+}
+
 // Opens a .esdb file for reading.
 func Open(path string) (*Db, error) {
+	return OpenWithOptions(path, OpenOptions{})
+}
+
+// Opens a .esdb file for reading, configured with the given options.
+func OpenWithOptions(path string, options OpenOptions) (*Db, error) {
+	cache, err := options.newCache()
+	if err != nil {
+		return nil, err
+	}
+
+	return openFile(path, cache)
+}
+
+// Opens an ESDB file of the given size for reading from r, such
+// as a bytes.Reader of the file's contents, or a reader of a remote
+// file. The Db reads r from as many goroutines as are using it.
+func OpenReader(r io.ReaderAt, size int64) (*Db, error) {
+	return OpenReaderWithOptions(r, size, OpenOptions{})
+}
+
+// Opens an ESDB file of the given size for reading
+// from r, configured with the given options.
+func OpenReaderWithOptions(r io.ReaderAt, size int64, options OpenOptions) (*Db, error) {
+	cache, err := options.newCache()
+	if err != nil {
+		return nil, err
+	}
+
+	return openReader(r, size, cache)
+}
+
+// Returns the block cache the options describe, if any.
+func (options OpenOptions) newCache() (*blocks.Cache, error) {
+	if options.BlockCacheBytes < 0 {
+		return nil, fmt.Errorf("esdb: invalid block cache size %d", options.BlockCacheBytes)
+	}
+
+	if options.BlockCacheBytes == 0 {
+		return nil, nil
+	}
+
+	return blocks.NewCache(options.BlockCacheBytes), nil
+}
+
+// Opens a .esdb file for reading, caching its blocks
+// in the cache, which may be nil.
+func openFile(path string, cache *blocks.Cache) (*Db, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	st, err := findIndex(file)
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	db, err := openReader(file, stat.Size(), cache)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	db.file = file
+
+	return db, nil
+}
+
+// Opens an ESDB file read from r, caching its
+// blocks in the cache, which may be nil.
+func openReader(r io.ReaderAt, size int64, cache *blocks.Cache) (*Db, error) {
+	header, err := readFileHeader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	fileCache := newBlockCache(cache)
+
+	st, err := findIndex(r, header, size, fileCache)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Db{
-		file:  file,
-		index: st,
+		reader: r,
+		size:   size,
+		header: header,
+		index:  st,
+		cache:  fileCache,
 	}, nil
 }
 
@@ -49,7 +145,9 @@ func (db *Db) Find(id []byte) *Space {
 		length := binary.ReadInt64(b)
 
 		return openSpace(
-			db.file,
+			db.reader,
+			db.header,
+			db.cache,
 			id,
 			offset,
 			length,
@@ -69,23 +167,42 @@ func (db *Db) Iterate(process func(s *Space) bool) error {
 			}
 		}
 
-		return nil
+		return iter.Close()
 	} else {
 		return err
 	}
 }
 
+// Returns the unit of the timestamps of the file's events.
+func (db *Db) Precision() Precision {
+	return db.header.precision
+}
+
+// Returns the hits and misses of the block cache, and what it holds.
+// They're all zero if the Db was opened without a block cache.
+func (db *Db) CacheStats() blocks.CacheStats {
+	return db.cache.stats()
+}
+
+// Closes the file the Db was opened from, if it was opened from
+// a path. Readers passed to OpenReader are left for the caller
+// to close.
 func (db *Db) Close() {
 	if db.file != nil {
 		db.file.Close()
 	}
+
+	if db.unmap != nil {
+		db.unmap()
+		db.unmap = nil
+	}
 }
 
-func findIndex(f *os.File) (*sst.Reader, error) {
-	// The last 8 bytes in the file is the length
-	// of the SSTable spaces index.
-	f.Seek(-8, 2)
-	indexLen := binary.ReadInt64(f)
+func findIndex(r io.ReaderAt, header fileHeader, size int64, cache *blockCache) (*sst.Reader, error) {
+	offset, length, err := header.locateIndex(r, size)
+	if err != nil {
+		return nil, err
+	}
 
-	return sst.NewReader(bounded.New(f, -8-indexLen, -8), indexLen)
+	return openTable(r, offset, length, cache)
 }
@@ -1,12 +1,17 @@
 package esdb
 
 import (
+	"bytes"
 	"encoding/csv"
 	"math/rand"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/customerio/esdb/blocks"
 )
 
 func fetchSpaceIndex(db *Db, id []byte, index, value string) []string {
@@ -24,6 +29,19 @@ func fetchSpaceIndex(db *Db, id []byte, index, value string) []string {
 	return found
 }
 
+func fetchSpaceGrouping(db *Db, id []byte, grouping string) []string {
+	found := make([]string, 0)
+
+	if space := db.Find(id); space != nil {
+		space.Scan(grouping, func(event *Event) bool {
+			found = append(found, string(event.Data))
+			return true
+		})
+	}
+
+	return found
+}
+
 var evs events
 
 func createDb() *Db {
@@ -254,3 +272,220 @@ func BenchmarkMillionEventDbScanIndex500(b *testing.B) {
 		})
 	}
 }
+
+func TestConcurrentSpaceScans(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+	os.Remove("tmp/concurrent.esdb")
+
+	w, err := New("tmp/concurrent.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2000; i++ {
+		data := []byte(strconv.Itoa(i) + strings.Repeat("-", 100))
+		w.Add([]byte(strconv.Itoa(i%8)), data, i, "", map[string]string{"even": strconv.FormatBool(i%2 == 0)})
+	}
+
+	if err = w.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scans share blocks through the cache, which is small
+	// enough for blocks to be evicted while they're scanned.
+	for _, options := range []OpenOptions{{}, {BlockCacheBytes: 16 << 10}} {
+		db, err := OpenWithOptions("tmp/concurrent.esdb", options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scanConcurrently(t, db)
+		db.Close()
+	}
+}
+
+func scanConcurrently(t *testing.T, db *Db) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		for _, reverse := range []bool{false, true} {
+			wg.Add(1)
+
+			go func(id int, reverse bool) {
+				defer wg.Done()
+
+				space := db.Find([]byte(strconv.Itoa(id)))
+
+				iter := space.Events("")
+				if reverse {
+					iter = space.RevIndexEvents("even", strconv.FormatBool(id%2 == 0))
+				}
+
+				count := 0
+
+				for iter.Next() {
+					if ts := iter.Event().Timestamp; ts%8 != id {
+						t.Errorf("Space %d: found event %d from another space", id, ts)
+					}
+					count += 1
+				}
+
+				if err := iter.Close(); err != nil || count != 250 {
+					t.Errorf("Space %d: wrong scan result: wanted: 250,<nil> found: %d,%v", id, count, err)
+				}
+			}(i, reverse)
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestBlockCache(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+	writeVisits(t, "tmp/cached.esdb", visits, WriterOptions{}, "")
+
+	uncached, err := Open("tmp/cached.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uncached.Close()
+
+	db, err := OpenWithOptions("tmp/cached.esdb", OpenOptions{BlockCacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expected := fetchSpaceIndex(uncached, []byte("Kingsbridge"), "type", "visit")
+
+	if len(expected) == 0 {
+		t.Fatal("Expected events to be found")
+	}
+
+	if found := fetchSpaceIndex(db, []byte("Kingsbridge"), "type", "visit"); !reflect.DeepEqual(found, expected) {
+		t.Errorf("Wrong events: wanted %d events, found %d", len(expected), len(found))
+	}
+
+	first := db.CacheStats()
+
+	if first.Misses == 0 || first.Blocks == 0 || first.Bytes == 0 {
+		t.Errorf("Expected blocks to be cached, found: %+v", first)
+	}
+
+	if found := fetchSpaceIndex(db, []byte("Kingsbridge"), "type", "visit"); !reflect.DeepEqual(found, expected) {
+		t.Errorf("Wrong events when cached: wanted %d events, found %d", len(expected), len(found))
+	}
+
+	// Every block, including those of the SSTables, is found in the cache.
+	second := db.CacheStats()
+
+	if second.Misses != first.Misses || second.Hits <= first.Hits {
+		t.Errorf("Expected blocks to be read from the cache:\n first:  %+v\n second: %+v", first, second)
+	}
+
+	if stats := uncached.CacheStats(); stats != (blocks.CacheStats{}) {
+		t.Errorf("Expected no cache stats without a cache, found: %+v", stats)
+	}
+
+	if _, err = OpenWithOptions("tmp/cached.esdb", OpenOptions{BlockCacheBytes: -1}); err == nil {
+		t.Errorf("Expected error for negative cache size")
+	}
+}
+
+func TestBlockCacheCopiesEvents(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 1000)
+	writeVisits(t, "tmp/cached.esdb", visits, WriterOptions{Codec: blocks.NoCompression}, "")
+
+	db, err := OpenWithOptions("tmp/cached.esdb", OpenOptions{BlockCacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expected := fetchSpaceGrouping(db, []byte(visits[0].City), visits[0].Host)
+
+	// Events read from cached blocks can be modified
+	// without changing the blocks in the cache.
+	db.Find([]byte(visits[0].City)).Scan(visits[0].Host, func(e *Event) bool {
+		copy(e.Data, "XXXXXX")
+		return true
+	})
+
+	if found := fetchSpaceGrouping(db, []byte(visits[0].City), visits[0].Host); len(found) == 0 || !reflect.DeepEqual(found, expected) {
+		t.Errorf("Wrong events after modifying cached events: wanted: %v found: %v", expected, found)
+	}
+}
+
+func TestFindAbsentSpaces(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+	writeVisits(t, "tmp/filtered.esdb", visits, WriterOptions{}, "")
+
+	if report, err := Verify("tmp/filtered.esdb"); err != nil || !report.Ok() {
+		t.Fatalf("Expected file to verify: %v %v", report, err)
+	}
+
+	// Reads of blocks are counted by the cache.
+	db, err := OpenWithOptions("tmp/filtered.esdb", OpenOptions{BlockCacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	space := db.Find([]byte("Kingsbridge"))
+	if space == nil {
+		t.Fatal("Expected space to be found")
+	}
+
+	before := db.CacheStats()
+
+	for i := 0; i < 1000; i++ {
+		if db.Find([]byte("absent"+strconv.Itoa(i))) != nil {
+			t.Errorf("Expected absent space %d not to be found", i)
+		}
+
+		space.ScanIndex("absent", strconv.Itoa(i), func(e *Event) bool {
+			t.Errorf("Expected absent index %d to be empty, found: %s", i, e.Data)
+			return false
+		})
+	}
+
+	// The bloom filters of the SSTables rule out
+	// nearly all absent spaces and indexes, without
+	// reading any of the tables' data blocks.
+	after := db.CacheStats()
+
+	if reads := after.Hits + after.Misses - before.Hits - before.Misses; reads > 100 {
+		t.Errorf("Too many blocks read finding absent spaces and indexes: %d", reads)
+	}
+}
+
+func TestOpenReader(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+	b := writeVisits(t, "tmp/reader.esdb", visits, WriterOptions{}, "")
+
+	db, err := Open("tmp/reader.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	read, err := OpenReaderWithOptions(bytes.NewReader(b), int64(len(b)), OpenOptions{BlockCacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer read.Close()
+
+	for _, v := range visits[:50] {
+		if expected, found := fetchSpaceIndex(db, []byte(v.City), "visitor", v.Visitor), fetchSpaceIndex(read, []byte(v.City), "visitor", v.Visitor); len(found) == 0 || !reflect.DeepEqual(found, expected) {
+			t.Errorf("%s visitor %s: wanted %d events, found %d", v.City, v.Visitor, len(expected), len(found))
+		}
+	}
+
+	if _, err = OpenReader(bytes.NewReader(b[:len(b)/2]), int64(len(b)/2)); err == nil {
+		t.Errorf("Expected error opening a truncated file")
+	}
+
+	if _, err = OpenReaderWithOptions(bytes.NewReader(b), int64(len(b)), OpenOptions{BlockCacheBytes: -1}); err == nil {
+		t.Errorf("Expected error opening with an invalid block cache size")
+	}
+}
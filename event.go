@@ -1,7 +1,10 @@
 package esdb
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
+	"time"
 
 	"github.com/customerio/esdb/binary"
 	"github.com/customerio/esdb/blocks"
@@ -14,33 +17,118 @@ func (e events) Less(i, j int) bool { return e[i].Timestamp < e[j].Timestamp }
 func (e events) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
 
 type Event struct {
-	Data      []byte
+	Data []byte
+
+	// The event's timestamp, in units of the file's precision.
 	Timestamp int
+
+	precision Precision
 	block     int64
 	offset    int
 }
 
 func newEvent(data []byte, timestamp int) *Event {
-	return &Event{data, timestamp, 0, 0}
+	return &Event{Data: data, Timestamp: timestamp}
+}
+
+// Returns the event's timestamp as a time.
+func (e *Event) Time() time.Time {
+	return e.precision.Time(e.Timestamp)
 }
 
 // Events are encoded in the following byte format:
+// [Uvarint:length][Varint:timestamp][bytes(length):data]
+//
+// Files written before version 2 of the format
+// use a 32 bit integer for the timestamp:
 // [Uvarint:length][int32:timestamp][bytes(length):data]
-func (e *Event) push(out io.Writer) {
+func (e *Event) push(out io.Writer, header fileHeader) {
 	binary.WriteUvarint(out, len(e.Data))
-	binary.WriteInt32(out, e.Timestamp)
+
+	if header.wideTimestamps() {
+		binary.WriteVarint(out, int64(e.Timestamp))
+	} else {
+		binary.WriteInt32(out, e.Timestamp)
+	}
+
 	out.Write(e.Data)
 	e.Data = nil
 }
 
-func pullEvent(r *blocks.Reader) (e *Event) {
-	size := binary.ReadUvarint(r)
-	timestamp := int(binary.ReadInt32(r))
-	data := binary.ReadBytes(r, size)
+// Pulls the next event from the reader. Returns a nil event once the
+// empty event marking the end of a grouping is reached, and an
+// error if the event is truncated or couldn't be read.
+func pullEvent(r *blocks.Reader, header fileHeader) (*Event, error) {
+	size, err := binary.ReadUvarintChecked(r)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	timestamp, err := pullTimestamp(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	data, stable, err := r.ReadSlice(int(size))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Data of uncompressed blocks of mapped
+	// files is used without copying it.
+	if !stable {
+		data = append([]byte(nil), data...)
+	}
+
+	return &Event{Data: data, Timestamp: timestamp, precision: header.precision}, nil
+}
+
+// Like pullEvent, but skips over the event's data, returning only its
+// timestamp, or false once the end of the grouping is reached.
+func skipEvent(r *blocks.Reader, header fileHeader) (int, bool, error) {
+	size, err := binary.ReadUvarintChecked(r)
+	if err == io.EOF {
+		return 0, false, io.ErrUnexpectedEOF
+	} else if err != nil || size == 0 {
+		return 0, false, err
+	}
+
+	timestamp, err := pullTimestamp(r, header)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if _, err = io.CopyN(ioutil.Discard, r, size); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return timestamp, err == nil, err
+}
+
+func pullTimestamp(r *blocks.Reader, header fileHeader) (int, error) {
+	if header.wideTimestamps() {
+		timestamp, err := binary.ReadVarintChecked(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return int(timestamp), err
+	}
 
-	if len(data) > 0 {
-		e = &Event{Data: data, Timestamp: timestamp}
+	head, err := binary.ReadFull(r, 4)
+	if err != nil {
+		return 0, err
 	}
 
-	return
+	return int(binary.ReadInt32(bytes.NewReader(head))), nil
 }
@@ -10,25 +10,54 @@ import (
 // writes all events associated with the given
 // grouping to the file in timestamp descending order.
 // Marks the event with which block it's located in,
-// as well as the offset within the block.
-func writeEventBlocks(i *index, out io.Writer) {
+// as well as the offset within the block, and records
+// the first event of each block in the grouping's summary.
+func writeEventBlocks(i *index, out io.Writer, header fileHeader) {
 	sort.Stable(sort.Reverse(i.evs))
 
-	writer := blocks.NewWriter(out, 4096)
+	writer := newEventBlockWriter(i, out, header)
 
 	for _, event := range i.evs {
-		// mark event with the current location in the file.
-		event.block = i.offset + int64(writer.Written)
-		event.offset = writer.Buffered()
+		writer.add(event)
+	}
+
+	writer.close()
+}
+
+// Writes the events of a grouping one at a time,
+// for events which are already sorted newest first.
+type eventBlockWriter struct {
+	i      *index
+	writer *blocks.Writer
+	header fileHeader
+}
 
-		// push the encoded event onto the buffer.
-		event.push(writer)
+func newEventBlockWriter(i *index, out io.Writer, header fileHeader) *eventBlockWriter {
+	return &eventBlockWriter{
+		i:      i,
+		writer: header.newBlockWriter(out),
+		header: header,
 	}
+}
+
+func (w *eventBlockWriter) add(event *Event) {
+	// mark event with the current location in the file.
+	event.block = w.i.offset + int64(w.writer.Written)
+	event.offset = w.writer.Buffered()
+
+	w.i.summary.add(event.block, event.offset, event.Timestamp)
+	w.i.stats.add(event.Timestamp)
+
+	// push the encoded event onto the buffer.
+	event.push(w.writer, w.header)
+}
 
+func (w *eventBlockWriter) close() {
 	// Mark the end of the grouping's events with an empty event.
-	writer.Write([]byte{0})
+	w.writer.Write([]byte{0})
 
-	writer.Flush()
+	w.writer.Flush()
 
-	i.length += int64(writer.Written)
+	w.i.length += int64(w.writer.Written)
+	w.i.stats.Blocks = w.writer.Blocks
 }
@@ -28,9 +28,9 @@ func TestWriteEventBlocksSmall(t *testing.T) {
 
 	index := &index{evs: events{e1, e2, e3, e4}}
 
-	writeEventBlocks(index, w)
+	writeEventBlocks(index, w, currentHeader())
 
-	expected := []byte("\x1d\x00\x00\x03\x04\x00\x00\x00def\x01\x03\x00\x00\x00b\x01\x02\x00\x00\x00c\x03\x01\x00\x00\x00abc\x00")
+	expected := []byte("\x11\x00\x80\x03\x08def\x01\x06b\x01\x04c\x03\x02abc\x00" + "\x71\x93\x25\x7f")
 
 	if !reflect.DeepEqual(w.Bytes(), expected) {
 		t.Errorf("Wrong event block bytecode:\n wanted: %x\n found:  %x", expected, w.Bytes())
@@ -47,9 +47,9 @@ func TestWriteEventBlocksSmall(t *testing.T) {
 		block  int64
 		offset int
 	}{
-		{e1, 0, 20},
-		{e2, 0, 8},
-		{e3, 0, 14},
+		{e1, 0, 11},
+		{e2, 0, 5},
+		{e3, 0, 8},
 		{e4, 0, 0},
 	}
 
@@ -72,16 +72,16 @@ func TestWriteEventBlocksMedium(t *testing.T) {
 
 	index := &index{evs: events{e1, e2, e3, e4}}
 
-	writeEventBlocks(index, w)
+	writeEventBlocks(index, w, currentHeader())
 
 	var tests = []struct {
 		event  *Event
 		block  int64
 		offset int
 	}{
-		{e1, 419, 226},
-		{e2, 0, 3406},
-		{e3, 211, 2116},
+		{e1, 419, 217},
+		{e2, 0, 3403},
+		{e3, 212, 2110},
 		{e4, 0, 0},
 	}
 
@@ -96,14 +96,14 @@ func TestWriteEventBlocksMedium(t *testing.T) {
 	r := blocks.NewByteReader(w.Bytes(), 4096)
 
 	for i, dataLen := range []int{3400, 2800, 2200, 2600} {
-		e := pullEvent(r)
+		e, _ := pullEvent(r, currentHeader())
 
 		if len(e.Data) != dataLen {
 			t.Errorf("Case %d: Wrong read data. wanted: %d bytes found: %d bytes", i, dataLen, len(e.Data))
 		}
 	}
 
-	if e := pullEvent(r); e != nil {
+	if e, _ := pullEvent(r, currentHeader()); e != nil {
 		t.Errorf("Found unexpected written event %v", e.Data)
 	}
 }
@@ -118,16 +118,16 @@ func TestWriteEventBlocksLarge(t *testing.T) {
 
 	index := &index{evs: events{e1, e2, e3, e4}}
 
-	writeEventBlocks(index, w)
+	writeEventBlocks(index, w, currentHeader())
 
 	var tests = []struct {
 		event  *Event
 		block  int64
 		offset int
 	}{
-		{e1, 2815, 2677},
-		{e2, 803, 3623},
-		{e3, 1809, 3150},
+		{e1, 2861, 2668},
+		{e2, 816, 3620},
+		{e3, 1839, 3144},
 		{e4, 0, 0},
 	}
 
@@ -142,14 +142,14 @@ func TestWriteEventBlocksLarge(t *testing.T) {
 	r := blocks.NewByteReader(w.Bytes(), 4096)
 
 	for i, dataLen := range []int{20000, 20000, 20000, 20000} {
-		e := pullEvent(r)
+		e, _ := pullEvent(r, currentHeader())
 
 		if len(e.Data) != dataLen {
 			t.Errorf("Case %d: Wrong read data. wanted: %d bytes found: %d bytes", i, dataLen, len(e.Data))
 		}
 	}
 
-	if e := pullEvent(r); e != nil {
+	if e, _ := pullEvent(r, currentHeader()); e != nil {
 		t.Errorf("Found unexpected written event %v", e.Data)
 	}
 }
@@ -93,6 +93,13 @@ func ExampleDb() {
 		return true // continue
 	})
 
+	// Just retrieve customer 3's clicks, oldest first
+	fmt.Println("\nclicks for 3, oldest first:")
+	db.Find([]byte("3")).RevScanIndex("type", "click", func(event *Event) bool {
+		fmt.Println(string(event.Data))
+		return true // continue
+	})
+
 	// Output:
 	// activity for 1:
 	// {"total":"42.99"}
@@ -112,4 +119,8 @@ func ExampleDb() {
 	// clicks for 3:
 	// {"button_text":"Checkout"}
 	// {"button_text":"About"}
+	//
+	// clicks for 3, oldest first:
+	// {"button_text":"About"}
+	// {"button_text":"Checkout"}
 }
@@ -0,0 +1,282 @@
+package esdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/customerio/esdb/binary"
+	"github.com/customerio/esdb/blocks"
+	"github.com/customerio/esdb/sst"
+)
+
+// Identifies a file as an ESDB file. Found at the very beginning
+// and very end of the file. The leading non-ASCII byte and line
+// endings catch files mangled by text mode transfers.
+const MAGIC = "\x89ESDB\r\n\x1a"
+
+// The current version of the file format, written by Writer.
+//
+// Version 2 introduced 64 bit timestamps and their precision.
+const VERSION = 2
+
+// Feature flags recorded in the file header.
+const (
+	// Each block is followed by a CRC32C checksum.
+	FLAG_BLOCK_CHECKSUMS = 1 << iota
+
+	// Indexes end with a terminating entry, which can't be
+	// mistaken for an entry, rather than a single 0 byte.
+	FLAG_INDEX_TERMINATORS
+
+	// Each index entry includes the timestamp of its event.
+	FLAG_INDEX_TIMESTAMPS
+)
+
+// Events are written in blocks of this many bytes, before
+// they're compressed, unless WriterOptions says otherwise.
+const DEFAULT_BLOCK_SIZE = 4096
+
+// The largest block size files can be written with. Each reader
+// holds a few blocks in memory, so they shouldn't be too large.
+const MAX_BLOCK_SIZE = 64 << 20
+
+// Flags which this version of the package knows how to read.
+const knownFlags = FLAG_BLOCK_CHECKSUMS | FLAG_INDEX_TERMINATORS | FLAG_INDEX_TIMESTAMPS
+
+const (
+	headerLen = 28 // magic + version + flags + codec + block size + precision
+	footerLen = 16 // index length + magic
+
+	// Version 1 headers didn't include the precision.
+	v1HeaderLen = 24
+)
+
+var (
+	ErrNotEsdb            = errors.New("esdb: not an esdb file")
+	ErrUnsupportedVersion = errors.New("esdb: unsupported file format version")
+	ErrUnsupportedFeature = errors.New("esdb: file uses unsupported features")
+)
+
+// The file header describes the format of the file's contents.
+// Files written before the header was introduced are read as
+// version 0, with the settings they were always written with.
+//
+// The header is 28 bytes in the following format:
+//
+//	[8 bytes:magic][uint32:version][uint32:flags][uint32:codec][uint32:block size][uint32:precision]
+//
+// Version 1 headers are 24 bytes, without the precision, which was
+// always seconds.
+//
+// And the footer, at the very end of the file, is 16 bytes:
+//
+//	[int64:spaces index length][8 bytes:magic]
+//
+// Files without a header simply end with the spaces index length.
+type fileHeader struct {
+	version   int
+	flags     int
+	codec     blocks.Codec
+	blockSize int
+	precision Precision
+}
+
+// The header written to new files.
+func currentHeader() fileHeader {
+	return fileHeader{
+		version:   VERSION,
+		flags:     FLAG_BLOCK_CHECKSUMS | FLAG_INDEX_TERMINATORS | FLAG_INDEX_TIMESTAMPS,
+		codec:     blocks.Snappy,
+		blockSize: DEFAULT_BLOCK_SIZE,
+		precision: Seconds,
+	}
+}
+
+// How files without a header were written.
+func legacyHeader() fileHeader {
+	return fileHeader{
+		version:   0,
+		codec:     blocks.Snappy,
+		blockSize: DEFAULT_BLOCK_SIZE,
+	}
+}
+
+// Offset of the first space within the file.
+func (h fileHeader) dataOffset() int64 {
+	switch h.version {
+	case 0:
+		return 0
+	case 1:
+		return v1HeaderLen
+	default:
+		return headerLen
+	}
+}
+
+// Events have 64 bit timestamps from version 2,
+// previously they were 32 bit.
+func (h fileHeader) wideTimestamps() bool {
+	return h.version >= 2
+}
+
+// Index entries include their event's timestamp when flagged.
+func (h fileHeader) indexTimestamps() bool {
+	return h.flags&FLAG_INDEX_TIMESTAMPS != 0
+}
+
+// Index entries locate events by their block, and their offset within
+// the block, which is 16 bits for blocks smaller than 64KiB, or 32 bits.
+func (h fileHeader) indexEntryLen() int {
+	if h.blockSize < 65536 {
+		return 10
+	}
+
+	return 12
+}
+
+// Each block begins with its length, which is 16 bits for blocks
+// smaller than 64KiB, or 32 bits, followed by its encoding byte.
+func (h fileHeader) blockHeaderLen() int {
+	if h.blockSize < 65536 {
+		return 3
+	}
+
+	return 5
+}
+
+// Returns a writer of blocks for the file's sections.
+func (h fileHeader) newBlockWriter(out io.Writer) *blocks.Writer {
+	return blocks.NewWriterWithOptions(out, h.blockSize, blocks.WriterOptions{
+		Codec:     h.codec,
+		Checksums: h.flags&FLAG_BLOCK_CHECKSUMS != 0,
+	})
+}
+
+func (h fileHeader) footerLen() int64 {
+	if h.version == 0 {
+		return 8
+	}
+
+	return footerLen
+}
+
+func (h fileHeader) write(out io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+
+	buf.WriteString(MAGIC)
+	binary.WriteInt32(buf, h.version)
+	binary.WriteInt32(buf, h.flags)
+	binary.WriteInt32(buf, h.codec.Encoding())
+	binary.WriteInt32(buf, h.blockSize)
+
+	if h.version >= 2 {
+		binary.WriteInt32(buf, int(h.precision))
+	}
+
+	return buf.WriteTo(out)
+}
+
+func writeFileFooter(out io.Writer, indexLen int64) (int64, error) {
+	buf := new(bytes.Buffer)
+
+	binary.WriteInt64(buf, indexLen)
+	buf.WriteString(MAGIC)
+
+	return buf.WriteTo(out)
+}
+
+// Reads the header of the file, checking this package is able to
+// read it. Files without a header are recognised by the SSTable
+// spaces index they end with.
+func readFileHeader(r io.ReaderAt, size int64) (fileHeader, error) {
+	if magic := binary.ReadBytesAt(r, int64(len(MAGIC)), 0); string(magic) != MAGIC {
+		if isLegacyFile(r, size) {
+			return legacyHeader(), nil
+		}
+
+		return fileHeader{}, ErrNotEsdb
+	}
+
+	if size < v1HeaderLen+footerLen {
+		return fileHeader{}, ErrNotEsdb
+	}
+
+	buf := bytes.NewReader(binary.ReadBytesAt(r, headerLen-int64(len(MAGIC)), int64(len(MAGIC))))
+
+	h := fileHeader{
+		version:   int(binary.ReadInt32(buf)),
+		flags:     int(binary.ReadInt32(buf)),
+		precision: Seconds,
+	}
+
+	encoding := int(binary.ReadInt32(buf))
+	h.blockSize = int(binary.ReadInt32(buf))
+
+	if h.version < 1 || h.version > VERSION {
+		return h, fmt.Errorf("%w %d", ErrUnsupportedVersion, h.version)
+	}
+
+	if h.version >= 2 {
+		if h.precision = Precision(binary.ReadInt32(buf)); !h.precision.valid() {
+			return h, fmt.Errorf("%w (precision %d)", ErrUnsupportedFeature, h.precision)
+		}
+	}
+
+	if h.flags&^knownFlags != 0 {
+		return h, fmt.Errorf("%w (flags %#x)", ErrUnsupportedFeature, h.flags&^knownFlags)
+	}
+
+	// Blocks are decompressed with the codec registered for the encoding
+	// recorded in each block, so the file's codec must be registered.
+	codec, ok := blocks.LookupCodec(encoding)
+	if !ok {
+		return h, fmt.Errorf("%w (codec %d)", ErrUnsupportedFeature, encoding)
+	}
+
+	h.codec = codec
+
+	if h.blockSize <= 0 || h.blockSize > MAX_BLOCK_SIZE {
+		return h, fmt.Errorf("%w (block size %d)", ErrUnsupportedFeature, h.blockSize)
+	}
+
+	return h, nil
+}
+
+// Files written before the header was introduced end
+// with an SSTable followed by its 8 byte length.
+func isLegacyFile(r io.ReaderAt, size int64) bool {
+	if size < 8+sst.FOOTER_SIZE {
+		return false
+	}
+
+	indexLen := binary.ReadInt64At(r, size-8)
+	if indexLen < sst.FOOTER_SIZE || indexLen > size-8 {
+		return false
+	}
+
+	magic := binary.ReadBytesAt(r, int64(len(sst.MAGIC)), size-8-int64(len(sst.MAGIC)))
+	return string(magic) == sst.MAGIC
+}
+
+// Returns the offset and length of the SSTable spaces index.
+func (h fileHeader) locateIndex(r io.ReaderAt, size int64) (int64, int64, error) {
+	footerOffset := size - h.footerLen()
+
+	if h.version > 0 {
+		if magic := binary.ReadBytesAt(r, int64(len(MAGIC)), size-int64(len(MAGIC))); string(magic) != MAGIC {
+			return 0, 0, errors.New("esdb: missing file footer, the file may be truncated")
+		}
+	}
+
+	// The first 8 bytes of the footer is
+	// the length of the SSTable spaces index.
+	indexLen := binary.ReadInt64At(r, footerOffset)
+
+	if indexLen <= 0 || indexLen > footerOffset-h.dataOffset() {
+		return 0, 0, errors.New("esdb: invalid spaces index length")
+	}
+
+	return footerOffset - indexLen, indexLen, nil
+}
@@ -0,0 +1,175 @@
+package esdb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/customerio/esdb/binary"
+	"github.com/customerio/esdb/sst"
+)
+
+// Writes a file the way earlier versions of the format were
+// written. Files of version 0 have no file header or footer.
+func writeLegacyFile(t *testing.T, path string, header fileHeader) {
+	buf := new(bytes.Buffer)
+
+	if header.version > 0 {
+		header.write(buf)
+	}
+
+	offset := int64(buf.Len())
+
+	space := newSpace(buf, header, []byte("a"))
+	space.add(newEvent([]byte("1"), 1), "g", map[string]string{"i": "x"})
+	space.add(newEvent([]byte("2"), 2), "g", map[string]string{"i": "y"})
+
+	length, err := space.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := new(bytes.Buffer)
+	st := sst.NewWriter(index)
+
+	val := new(bytes.Buffer)
+	binary.WriteInt64(val, offset)
+	binary.WriteInt64(val, length)
+	st.Set([]byte("a"), val.Bytes())
+	st.Close()
+
+	indexLen, _ := index.WriteTo(buf)
+
+	if header.version > 0 {
+		writeFileFooter(buf, indexLen)
+	} else {
+		binary.WriteInt64(buf, indexLen)
+	}
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileHeader(t *testing.T) {
+	createDb().Close()
+
+	b, _ := ioutil.ReadFile("tmp/test.esdb")
+
+	header := []byte(MAGIC + "\x02\x00\x00\x00" + "\x07\x00\x00\x00" + "\x01\x00\x00\x00" + "\x00\x10\x00\x00" + "\x00\x00\x00\x00")
+
+	if !reflect.DeepEqual(b[:headerLen], header) {
+		t.Errorf("Wrong file header:\n wanted: %x\n found:  %x", header, b[:headerLen])
+	}
+
+	if footer := b[len(b)-len(MAGIC):]; string(footer) != MAGIC {
+		t.Errorf("Wrong file footer magic: wanted: %q found: %q", MAGIC, footer)
+	}
+
+	if b[headerLen] != 42 {
+		t.Errorf("Expected first space to follow the file header, found: %x", b[headerLen])
+	}
+}
+
+func TestOpenLegacyFile(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+
+	v1 := currentHeader()
+	v1.version = 1
+
+	for _, header := range []fileHeader{legacyHeader(), v1} {
+		os.Remove("tmp/legacy.esdb")
+		writeLegacyFile(t, "tmp/legacy.esdb", header)
+
+		db, err := Open("tmp/legacy.esdb")
+		if err != nil {
+			t.Fatalf("Version %d: unable to open legacy file: %v", header.version, err)
+		}
+
+		if db.header.version != header.version {
+			t.Errorf("Wrong version for legacy file: wanted: %d found: %d", header.version, db.header.version)
+		}
+
+		if found := fetchSpaceIndex(db, []byte("a"), "i", "y"); !reflect.DeepEqual(found, []string{"2"}) {
+			t.Errorf("Version %d: wrong events for legacy file: wanted: [2] found: %v", header.version, found)
+		}
+
+		if ts := db.Find([]byte("a")).Events("g"); ts.Next() && ts.Event().Timestamp != 2 {
+			t.Errorf("Version %d: wrong timestamp for legacy file: wanted: 2 found: %d", header.version, ts.Event().Timestamp)
+		}
+
+		found := make([]string, 0)
+		err = db.Find([]byte("a")).RevScan("g", func(e *Event) bool {
+			found = append(found, string(e.Data))
+			return true
+		})
+
+		if err != nil || !reflect.DeepEqual(found, []string{"1", "2"}) {
+			t.Errorf("Version %d: wrong events scanning legacy file oldest first: wanted: [1 2] found: %v %v", header.version, found, err)
+		}
+
+		if report, err := Verify("tmp/legacy.esdb"); err != nil || !report.Ok() {
+			t.Errorf("Version %d: legacy file failed verification: %v %v", header.version, err, report.Problems)
+		}
+
+		db.Close()
+	}
+}
+
+func TestOpenForeignFile(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+
+	var tests = [][]byte{
+		[]byte(""),
+		[]byte("hello world, I'm not an esdb file at all"),
+		bytes.Repeat([]byte{0xff}, 1024),
+	}
+
+	for i, contents := range tests {
+		ioutil.WriteFile("tmp/foreign.esdb", contents, 0644)
+
+		if db, err := Open("tmp/foreign.esdb"); err != ErrNotEsdb {
+			t.Errorf("Case #%d: wrong error: wanted: %v found: %v", i, ErrNotEsdb, err)
+
+			if db != nil {
+				db.Close()
+			}
+		}
+	}
+}
+
+func TestOpenUnsupportedFile(t *testing.T) {
+	createDb().Close()
+
+	original, _ := ioutil.ReadFile("tmp/test.esdb")
+
+	var tests = []struct {
+		offset int
+		value  byte
+		err    error
+	}{
+		{8, 3, ErrUnsupportedVersion},
+		{8, 0, ErrUnsupportedVersion},
+		{12, 0x87, ErrUnsupportedFeature},
+		{16, 9, ErrUnsupportedFeature},
+		{23, 0x10, ErrUnsupportedFeature},
+		{24, 4, ErrUnsupportedFeature},
+	}
+
+	for i, test := range tests {
+		b := append([]byte{}, original...)
+		b[test.offset] = test.value
+		ioutil.WriteFile("tmp/unsupported.esdb", b, 0644)
+
+		if db, err := Open("tmp/unsupported.esdb"); !errors.Is(err, test.err) {
+			t.Errorf("Case #%d: wrong error: wanted: %v found: %v", i, test.err, err)
+
+			if db != nil {
+				db.Close()
+			}
+		}
+	}
+}
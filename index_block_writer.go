@@ -1,6 +1,7 @@
 package esdb
 
 import (
+	"bytes"
 	"io"
 	"sort"
 
@@ -8,28 +9,135 @@ import (
 	"github.com/customerio/esdb/blocks"
 )
 
+// The entry marking the end of an index. No event is
+// located in block -1, so it's never a real entry.
+func (h fileHeader) indexTerminator() []byte {
+	return bytes.Repeat([]byte{0xff}, h.indexEntryLen())
+}
+
+// The location of an event, as read from an index. The
+// timestamp is only known for files with index timestamps.
+type indexEntry struct {
+	block     int64
+	offset    int64
+	timestamp int
+}
+
 // writes block/offset locations for all events
 // associated with the given index to the file
 // in timestamp descending order.
-// Marks the event with which block it's located in,
-// as well as the offset within the block.
-func writeIndexBlocks(i *index, out io.Writer) {
+// Records the first entry of each block in the
+// index's summary.
+func writeIndexBlocks(i *index, out io.Writer, header fileHeader) {
 	sort.Stable(sort.Reverse(i.evs))
 
-	writer := blocks.NewWriter(out, 4096)
+	writer := newIndexBlockWriter(i, out, header)
 
 	for _, event := range i.evs {
-		// Each entry in the index is
-		// the block the event is located in,
-		// and the offset within the block.
-		binary.WriteInt64(writer, event.block)
-		binary.WriteInt16(writer, event.offset)
+		writer.add(event)
+	}
+
+	writer.close()
+}
+
+// Writes the entries of an index one at a time,
+// for events which are already sorted newest first,
+// and have been marked with their location.
+type indexBlockWriter struct {
+	i      *index
+	writer *blocks.Writer
+	header fileHeader
+}
+
+func newIndexBlockWriter(i *index, out io.Writer, header fileHeader) *indexBlockWriter {
+	return &indexBlockWriter{
+		i:      i,
+		writer: header.newBlockWriter(out),
+		header: header,
 	}
+}
+
+func (w *indexBlockWriter) add(event *Event) {
+	w.i.summary.add(w.i.offset+int64(w.writer.Written), w.writer.Buffered(), event.Timestamp)
+	w.i.stats.add(event.Timestamp)
 
-	// Mark the end of the grouping's events with an empty event.
-	writer.Write([]byte{0})
+	// Each entry in the index is
+	// the block the event is located in,
+	// and the offset within the block.
+	binary.WriteInt64(w.writer, event.block)
+
+	if w.header.indexEntryLen() == 10 {
+		binary.WriteInt16(w.writer, event.offset)
+	} else {
+		binary.WriteInt32(w.writer, event.offset)
+	}
 
-	writer.Flush()
+	if w.header.indexTimestamps() {
+		binary.WriteVarint(w.writer, int64(event.Timestamp))
+	}
+}
+
+func (w *indexBlockWriter) close() {
+	// Mark the end of the index. Files written before
+	// terminators were introduced used a single 0 byte.
+	if w.header.flags&FLAG_INDEX_TERMINATORS != 0 {
+		w.writer.Write(w.header.indexTerminator())
+	} else {
+		w.writer.Write([]byte{0})
+	}
+
+	w.writer.Flush()
+
+	w.i.length += int64(w.writer.Written)
+	w.i.stats.Blocks = w.writer.Blocks
+}
+
+// Reads the next entry of an index, returning a nil entry
+// once the end of the index has been reached.
+//
+// Files written before terminators were introduced end each
+// index with a single 0 byte. As entries can also begin with a
+// 0 byte, that's only the end of the index if it's the last byte
+// of the section, so the reader must be bounded to the section.
+func pullIndexEntry(r *blocks.Reader, header fileHeader) (*indexEntry, error) {
+	entryLen := header.indexEntryLen()
+	next := r.Peek(entryLen)
+
+	if header.flags&FLAG_INDEX_TERMINATORS != 0 {
+		if bytes.Equal(next, header.indexTerminator()) {
+			return nil, nil
+		}
+	} else if len(next) == 1 && next[0] == 0 {
+		return nil, nil
+	}
+
+	if len(next) < entryLen {
+		return nil, unexpectedEnd(r)
+	}
+
+	b, err := binary.ReadFull(r, int64(entryLen))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &indexEntry{block: binary.ReadInt64(bytes.NewReader(b[:8]))}
+
+	if entryLen == 10 {
+		entry.offset = binary.ReadInt16(bytes.NewReader(b[8:]))
+	} else {
+		entry.offset = binary.ReadInt32(bytes.NewReader(b[8:]))
+	}
+
+	if header.indexTimestamps() {
+		timestamp, err := binary.ReadVarintChecked(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		entry.timestamp = int(timestamp)
+
+		return entry, err
+	}
 
-	i.length += int64(writer.Written)
+	return entry, nil
 }
@@ -21,20 +21,21 @@ func TestWriteIndexBlocksSmall(t *testing.T) {
 
 	index := &index{evs: events{e1, e2, e3, e4}}
 
-	writeIndexBlocks(index, w)
+	writeIndexBlocks(index, w, currentHeader())
 
-	if index.length != 36 {
-		t.Errorf("Wrong written length: wanted: 36, found: %d", index.length)
+	if index.length != 49 {
+		t.Errorf("Wrong written length: wanted: 49, found: %d", index.length)
 	}
 
 	compressed := snappy.Encode(nil, []byte(
-		"\xF8\x00\x00\x00\x00\x00\x00\x00"+"\x00\x04"+
-			"\x00\x02\x00\x00\x00\x00\x00\x00"+"\x80\x00"+
-			"\x00\x08\x00\x00\x00\x00\x00\x00"+"\x00\x02"+
-			"\x00\x00\x00\x00\x00\x00\x00\x00"+"\x00\x08"+
-			"\x00"))
+		"\xF8\x00\x00\x00\x00\x00\x00\x00"+"\x00\x04"+"\x08"+
+			"\x00\x02\x00\x00\x00\x00\x00\x00"+"\x80\x00"+"\x06"+
+			"\x00\x08\x00\x00\x00\x00\x00\x00"+"\x00\x02"+"\x04"+
+			"\x00\x00\x00\x00\x00\x00\x00\x00"+"\x00\x08"+"\x02"+
+			"\xff\xff\xff\xff\xff\xff\xff\xff"+"\xff\xff"))
 
-	expected := append([]byte("\x21\x00\x01"), compressed...)
+	expected := append([]byte("\x2a\x00\x81"), compressed...)
+	expected = append(expected, "\x76\xf4\x77\x0e"...)
 
 	if !reflect.DeepEqual(w.Bytes(), expected) {
 		t.Errorf("Wrong event block bytecode:\n wanted: %x\n found:  %x", expected, w.Bytes())
@@ -51,10 +52,15 @@ func TestWriteIndexBlocksSmall(t *testing.T) {
 	for i, event := range index.evs {
 		block := binary.ReadInt64(reader)
 		offset := binary.ReadInt16(reader)
+		timestamp := int(binary.ReadVarint(reader))
 
 		if event.block != block || event.offset != int(offset) {
 			t.Errorf("Case %d: Wrong read event block/offset. wanted: %d,%d found: %d,%d", i, event.block, event.offset, block, offset)
 		}
+
+		if event.Timestamp != timestamp {
+			t.Errorf("Case %d: Wrong read event timestamp. wanted: %d found: %d", i, event.Timestamp, timestamp)
+		}
 	}
 }
 
@@ -67,10 +73,10 @@ func TestWriteIndexBlocksMedium(t *testing.T) {
 		index.evs[i] = &Event{Timestamp: i, block: rand.Int63(), offset: rand.Intn(4096)}
 	}
 
-	writeIndexBlocks(index, w)
+	writeIndexBlocks(index, w, currentHeader())
 
-	if index.length != 5007 {
-		t.Errorf("Wrong written length: wanted: 5007, found: %d", index.length)
+	if index.length != 5960 {
+		t.Errorf("Wrong written length: wanted: 5960, found: %d", index.length)
 	}
 
 	reader := blocks.NewByteReader(w.Bytes(), 4096)
@@ -78,10 +84,15 @@ func TestWriteIndexBlocksMedium(t *testing.T) {
 	for i, event := range index.evs {
 		block := binary.ReadInt64(reader)
 		offset := binary.ReadInt16(reader)
+		timestamp := int(binary.ReadVarint(reader))
 
 		if event.block != block || event.offset != int(offset) {
 			t.Errorf("Case %d: Wrong read event block/offset. wanted: %d,%d found: %d,%d", i, event.block, event.offset, block, offset)
 		}
+
+		if event.Timestamp != timestamp {
+			t.Errorf("Case %d: Wrong read event timestamp. wanted: %d found: %d", i, event.Timestamp, timestamp)
+		}
 	}
 }
 
@@ -94,10 +105,10 @@ func TestWriteIndexBlocksLarge(t *testing.T) {
 		index.evs[i] = &Event{Timestamp: i, block: rand.Int63(), offset: rand.Intn(4096)}
 	}
 
-	writeIndexBlocks(index, w)
+	writeIndexBlocks(index, w, currentHeader())
 
-	if index.length != 50040 {
-		t.Errorf("Wrong written length: wanted: 50040, found: %d", index.length)
+	if index.length != 60051 {
+		t.Errorf("Wrong written length: wanted: 60051, found: %d", index.length)
 	}
 
 	reader := blocks.NewByteReader(w.Bytes(), 4096)
@@ -105,9 +116,49 @@ func TestWriteIndexBlocksLarge(t *testing.T) {
 	for i, event := range index.evs {
 		block := binary.ReadInt64(reader)
 		offset := binary.ReadInt16(reader)
+		timestamp := int(binary.ReadVarint(reader))
 
 		if event.block != block || event.offset != int(offset) {
 			t.Errorf("Case %d: Wrong read event block/offset. wanted: %d,%d found: %d,%d", i, event.block, event.offset, block, offset)
 		}
+
+		if event.Timestamp != timestamp {
+			t.Errorf("Case %d: Wrong read event timestamp. wanted: %d found: %d", i, event.Timestamp, timestamp)
+		}
+	}
+}
+
+func TestPullIndexEntries(t *testing.T) {
+	// Entries for events in blocks with a 0 low byte
+	// begin with the same byte files written before
+	// terminators were introduced ended indexes with.
+	e1 := &Event{Timestamp: 3, block: 0, offset: 0}
+	e2 := &Event{Timestamp: 2, block: 256, offset: 12}
+	e3 := &Event{Timestamp: 1, block: 4096, offset: 0}
+
+	for _, header := range []fileHeader{currentHeader(), legacyHeader()} {
+		w := new(bytes.Buffer)
+		writeIndexBlocks(&index{evs: events{e1, e2, e3}}, w, header)
+
+		reader := blocks.NewByteReader(w.Bytes(), 4096)
+
+		found := make([]int64, 0)
+
+		for {
+			entry, err := pullIndexEntry(reader, header)
+			if err != nil {
+				t.Fatalf("Version %d: unable to read index: %v", header.version, err)
+			}
+
+			if entry == nil {
+				break
+			}
+
+			found = append(found, entry.block)
+		}
+
+		if want := []int64{0, 256, 4096}; !reflect.DeepEqual(found, want) {
+			t.Errorf("Version %d: wrong index entries: wanted: %v found: %v", header.version, want, found)
+		}
 	}
 }
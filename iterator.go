@@ -0,0 +1,127 @@
+package esdb
+
+import (
+	"io"
+
+	"github.com/customerio/esdb/blocks"
+)
+
+// EventIterator steps through the events of a grouping or index,
+// newest first.
+//
+//	iter := space.Events("grouping")
+//	defer iter.Close()
+//
+//	for iter.Next() {
+//	    process(iter.Event())
+//	}
+//
+//	if err := iter.Err(); err != nil {
+//	    // the file is truncated or corrupted.
+//	}
+type EventIterator interface {
+	// Advances to the next event, returning false once there
+	// are no more events or an error was encountered.
+	Next() bool
+
+	// Returns the current event.
+	Event() *Event
+
+	// Returns the error which stopped iteration, if any.
+	Err() error
+
+	// Stops iteration, and returns the same as Err.
+	Close() error
+}
+
+type eventIterator struct {
+	space  *Space
+	header fileHeader
+	events *blocks.Reader
+	index  *blocks.Reader
+	from   int
+	to     int
+	event  *Event
+	err    error
+	done   bool
+}
+
+// An iterator over no events, for groupings and indexes which
+// don't exist, or couldn't be found.
+func emptyIterator(err error) EventIterator {
+	return &eventIterator{err: err, done: true}
+}
+
+func (i *eventIterator) Next() bool {
+	for !i.done {
+		event, err := i.pull()
+
+		if err != nil {
+			i.err = err
+			break
+		}
+
+		// Events are stored newest first, so once
+		// we're past the range there's nothing left.
+		if event == nil || event.Timestamp < i.from {
+			break
+		}
+
+		if event.Timestamp <= i.to {
+			i.event = event
+			return true
+		}
+	}
+
+	i.Close()
+	return false
+}
+
+func (i *eventIterator) Event() *Event {
+	return i.event
+}
+
+func (i *eventIterator) Err() error {
+	return i.err
+}
+
+func (i *eventIterator) Close() error {
+	i.done = true
+	i.event = nil
+	return i.err
+}
+
+// Pulls the next event, either sequentially from a grouping,
+// or from the location of the next entry in an index.
+func (i *eventIterator) pull() (*Event, error) {
+	if i.index == nil {
+		return pullEvent(i.events, i.header)
+	}
+
+	// Each entry in the index is a 64 bit integer for the
+	// event's block offset in the file, and a 16 or 32 bit
+	// integer, depending on the block size, for the event's
+	// offset within the block.
+	entry, err := pullIndexEntry(i.index, i.header)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	// Move to the event's location within its block.
+	if err = i.space.seekBlock(i.events, entry.block, entry.offset); err != nil {
+		return nil, err
+	}
+
+	return pullEvent(i.events, i.header)
+}
+
+// Returns the error which left the reader without any more data,
+// such as a corrupted block, or io.ErrUnexpectedEOF if it simply
+// ran out.
+func unexpectedEnd(r *blocks.Reader) error {
+	if _, err := r.ReadByte(); err != nil && err != io.EOF {
+		return err
+	}
+
+	return io.ErrUnexpectedEOF
+}
@@ -0,0 +1,146 @@
+package esdb
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/customerio/esdb/blocks"
+)
+
+func TestSpaceEventIterator(t *testing.T) {
+	space := create([]byte("a"))
+
+	var tests = []struct {
+		iter EventIterator
+		want []string
+	}{
+		{space.Events("a"), []string{"1"}},
+		{space.Events("b"), []string{"2", "3"}},
+		{space.Events("c"), []string{}},
+		{space.IndexEvents("ts", ""), []string{"2", "1", "3"}},
+		{space.IndexEvents("i", "i1"), []string{"1", "3"}},
+		{space.IndexEvents("i", "i3"), []string{}},
+		{space.EventsRange("b", 2, 3), []string{"2"}},
+		{space.IndexEventsRange("ts", "", 1, 2), []string{"1", "3"}},
+	}
+
+	for i, test := range tests {
+		found := make([]string, 0)
+
+		for test.iter.Next() {
+			found = append(found, string(test.iter.Event().Data))
+		}
+
+		if err := test.iter.Err(); err != nil {
+			t.Errorf("Case #%v: unexpected error: %v", i, err)
+		}
+
+		if !reflect.DeepEqual(test.want, found) {
+			t.Errorf("Case #%v: wanted: %v, found: %v", i, test.want, found)
+		}
+	}
+}
+
+func TestInterleavedEventIterators(t *testing.T) {
+	space := createLarge(200)
+
+	all := space.Events("")
+	even := space.IndexEvents("even", "true")
+
+	defer all.Close()
+	defer even.Close()
+
+	for ts := 199; ts >= 0; ts-- {
+		if !all.Next() || all.Event().Timestamp != ts {
+			t.Fatalf("Wrong grouping event: wanted: %d, found: %v (%v)", ts, all.Event(), all.Err())
+		}
+
+		if ts%2 == 0 {
+			if !even.Next() || even.Event().Timestamp != ts {
+				t.Fatalf("Wrong index event: wanted: %d, found: %v (%v)", ts, even.Event(), even.Err())
+			}
+		}
+	}
+
+	if all.Next() || even.Next() {
+		t.Errorf("Found unexpected events after the end of the iterators")
+	}
+}
+
+func TestEventIteratorClose(t *testing.T) {
+	space := createLarge(200)
+
+	iter := space.Events("")
+
+	if !iter.Next() {
+		t.Fatalf("Expected an event, found error: %v", iter.Err())
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Errorf("Unexpected error closing iterator: %v", err)
+	}
+
+	if iter.Next() || iter.Event() != nil {
+		t.Errorf("Found unexpected event after closing the iterator")
+	}
+}
+
+func TestEventIteratorTruncated(t *testing.T) {
+	w := new(bytes.Buffer)
+
+	writeEventBlocks(&index{evs: events{
+		newEvent(generate(3000), 1),
+		newEvent(generate(3000), 2),
+	}}, w, currentHeader())
+
+	// Cut off the end of the last block.
+	truncated := w.Bytes()[:w.Len()-10]
+
+	iter := &eventIterator{
+		header: currentHeader(),
+		events: blocks.NewByteReader(truncated, 4096),
+		from:   math.MinInt64,
+		to:     math.MaxInt64,
+	}
+
+	for iter.Next() {
+	}
+
+	if err := iter.Err(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Wrong error for truncated events: wanted: %v, found: %v", io.ErrUnexpectedEOF, err)
+	}
+
+	if err := iter.Close(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Wrong error closing iterator: wanted: %v, found: %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestEventIteratorCorrupted(t *testing.T) {
+	w := new(bytes.Buffer)
+
+	writeEventBlocks(&index{evs: events{
+		newEvent(generate(3000), 1),
+		newEvent(generate(3000), 2),
+	}}, w, currentHeader())
+
+	// Flip a bit within the data of the last block.
+	corrupted := append([]byte{}, w.Bytes()...)
+	corrupted[len(corrupted)-10] ^= 0x01
+
+	iter := &eventIterator{
+		header: currentHeader(),
+		events: blocks.NewByteReader(corrupted, 4096),
+		from:   math.MinInt64,
+		to:     math.MaxInt64,
+	}
+
+	for iter.Next() {
+	}
+
+	if _, ok := iter.Err().(*blocks.ErrChecksumMismatch); !ok {
+		t.Errorf("Wrong error for corrupted events: wanted: checksum mismatch, found: %v", iter.Err())
+	}
+}
@@ -0,0 +1,63 @@
+package esdb
+
+import (
+	"io"
+	"math"
+
+	"github.com/customerio/esdb/blocks"
+	"github.com/customerio/esdb/sst"
+)
+
+// The contents of a memory mapped file. Blocks and SSTables are read
+// directly from the mapping, rather than copied, and any number of
+// goroutines can read it at once, as it's never modified.
+type mapping []byte
+
+func (m mapping) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Returns a reader of the blocks of r, which must be the whole file,
+// up until end. Mapped files are read without copying.
+func newBlockReader(r io.ReaderAt, end int64, blockSize int, cache *blockCache) *blocks.Reader {
+	if m, ok := r.(mapping); ok {
+		if end > int64(len(m)) {
+			end = int64(len(m))
+		}
+
+		return blocks.NewSliceReader(m[:end], blockSize)
+	}
+
+	if end < math.MaxInt64 {
+		r = io.NewSectionReader(r, 0, end)
+	}
+
+	return cache.newReader(r, blockSize)
+}
+
+// Opens the SSTable found at the offset within r, which must be the
+// whole file. Mapped files are read without copying.
+func openTable(r io.ReaderAt, offset, length int64, cache *blockCache) (*sst.Reader, error) {
+	if m, ok := r.(mapping); ok {
+		if offset < 0 || length < 0 || offset+length > int64(len(m)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return sst.NewSliceReader(m[offset : offset+length])
+	}
+
+	return cache.newTableReader(r, offset, length)
+}
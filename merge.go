@@ -0,0 +1,331 @@
+package esdb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/customerio/esdb/blocks"
+	"github.com/customerio/esdb/sst"
+)
+
+// The memory limit of merges, when the
+// options don't have a MemoryLimit.
+const defaultMergeMemoryLimit = 64 << 20
+
+// Merges the ESDB files at srcs into a new file at dst, written with
+// the given options. Spaces with the same id are combined, and their
+// groupings and indexes merged, newest first. Events with the same
+// timestamp are ordered by the order of srcs.
+//
+// Spaces are read from each file as they're written, so only a
+// limited amount of events are held in memory. The MemoryLimit of
+// the options bounds the memory used to sort the entries of indexes,
+// which are spilled to the TempDir beyond it. It defaults to 64MiB.
+//
+// Timestamps are converted to the precision of the options, which
+// can't be coarser than the precision of any of the files.
+//
+// If the files can't be merged, nothing is written to dst.
+func Merge(dst string, srcs []string, options WriterOptions) (err error) {
+	dbs := make([]*Db, 0, len(srcs))
+
+	defer func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}()
+
+	for _, path := range srcs {
+		db, err := Open(path)
+		if err != nil {
+			return err
+		}
+
+		dbs = append(dbs, db)
+
+		if db.Precision().Duration() < options.Precision.Duration() {
+			return fmt.Errorf("esdb: can't merge %s with %s timestamps into a file with %s timestamps", path, db.Precision(), options.Precision)
+		}
+	}
+
+	if options.MemoryLimit <= 0 {
+		options.MemoryLimit = defaultMergeMemoryLimit
+	}
+
+	w, err := NewWithOptions(dst, options)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			w.Abort()
+		}
+	}()
+
+	ids, err := newSpaceMerger(dbs)
+	if err != nil {
+		return err
+	}
+
+	for {
+		id, spaces, err := ids.next()
+		if err != nil {
+			return err
+		}
+
+		if spaces == nil {
+			break
+		}
+
+		if err = w.writeMergedSpace(id, spaces); err != nil {
+			return err
+		}
+	}
+
+	return w.Write()
+}
+
+// Writes a space from the events of the same space in several files,
+// in order of the files. Each file's events are already sorted, so
+// they're merged as they're read, while the space's index entries
+// are sorted, as when writing a space with a MemoryLimit.
+func (w *Writer) writeMergedSpace(id string, spaces []*Space) error {
+	// The memory limit is shared by the sorts of each file's
+	// index entries, and of the merged space's index entries.
+	limit := w.options.MemoryLimit / int64(len(spaces)+1)
+
+	records := &mergeIterator{}
+	sorters := make([]*recordSorter, 0, len(spaces))
+
+	defer func() {
+		for _, sorter := range sorters {
+			sorter.close()
+		}
+	}()
+
+	for i, space := range spaces {
+		sorter := newRecordSorter(limit, w.options.TempDir)
+		sorters = append(sorters, sorter)
+
+		cursor, err := newSpaceCursor(space, w.header.precision, int64(i), sorter)
+		if err != nil {
+			return err
+		}
+
+		records.push(cursor)
+	}
+
+	return w.writeRecords(id, records, limit)
+}
+
+// Steps through the space ids of several files in order,
+// returning the spaces with each id.
+type spaceMerger struct {
+	dbs   []*Db
+	iters []sst.Iterator
+	heads [][]byte
+	done  []bool
+}
+
+func newSpaceMerger(dbs []*Db) (*spaceMerger, error) {
+	m := &spaceMerger{
+		dbs:   dbs,
+		iters: make([]sst.Iterator, len(dbs)),
+		heads: make([][]byte, len(dbs)),
+		done:  make([]bool, len(dbs)),
+	}
+
+	for i, db := range dbs {
+		iter, err := db.index.Find([]byte(""))
+		if err != nil {
+			return nil, err
+		}
+
+		m.iters[i] = iter
+
+		if err = m.advance(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Returns the next space id, and the spaces with that id in order of
+// the files, or nil spaces once there are no more.
+func (m *spaceMerger) next() (string, []*Space, error) {
+	var id []byte
+
+	for i, head := range m.heads {
+		if !m.done[i] && (id == nil || bytes.Compare(head, id) < 0) {
+			id = head
+		}
+	}
+
+	if id == nil {
+		return "", nil, nil
+	}
+
+	spaces := make([]*Space, 0, len(m.dbs))
+
+	for i, head := range m.heads {
+		if m.done[i] || !bytes.Equal(head, id) {
+			continue
+		}
+
+		space := m.dbs[i].Find(id)
+		if space == nil {
+			return "", nil, fmt.Errorf("esdb: couldn't open space %q", id)
+		}
+
+		spaces = append(spaces, space)
+
+		if err := m.advance(i); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return string(id), spaces, nil
+}
+
+func (m *spaceMerger) advance(i int) error {
+	if m.iters[i].Next() {
+		m.heads[i] = append([]byte(nil), m.iters[i].Key()...)
+		return nil
+	}
+
+	m.done[i] = true
+	return m.iters[i].Close()
+}
+
+// A cursor over the events of a space, as records sorted by section
+// and then newest first, with the keys of the indexes each event is
+// in. Groupings are read in the order of their keys, which is the
+// order they're stored in, as are the events within each grouping,
+// so events are read in order of their location. The index entries
+// are sorted by the location of their events, so the keys of each
+// event's indexes are found by stepping through both together.
+type spaceCursor struct {
+	space    *Space
+	sections sst.Iterator
+	sec      *section
+	name     string
+	events   *blocks.Reader
+	entries  *mergeIterator
+	entry    *record
+	scale    int
+	seq      int64
+}
+
+// Creates a cursor over the space's events, with timestamps converted
+// to the given precision. The events' seqs begin with rank, so events
+// with the same timestamp in different spaces are ordered by rank.
+func newSpaceCursor(space *Space, precision Precision, rank int64, sorter *recordSorter) (*spaceCursor, error) {
+	if err := sortIndexEntries(space, sorter); err != nil {
+		return nil, err
+	}
+
+	sections, err := space.index.Find([]byte("g"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &spaceCursor{
+		space:    space,
+		sections: sections,
+		entries:  sorter.take(""),
+		scale:    int(space.header.precision.Duration() / precision.Duration()),
+		seq:      rank << 40,
+	}
+
+	c.entry, err = c.entries.next()
+
+	return c, err
+}
+
+// Adds an entry to the sorter for each entry of the space's
+// indexes, with the index's key and the event's location.
+func sortIndexEntries(space *Space, sorter *recordSorter) error {
+	iter, err := space.index.Find([]byte("i"))
+	if err != nil {
+		return err
+	}
+
+	for iter.Next() {
+		key := string(iter.Key())
+		sec := decodeSection(iter.Value())
+		r := sectionBlocks(space, sec)
+
+		for {
+			entry, err := pullIndexEntry(r, space.header)
+			if err != nil {
+				iter.Close()
+				return fmt.Errorf("esdb: space %q: index %q: %v", space.Id, key[1:], err)
+			}
+
+			if entry == nil {
+				break
+			}
+
+			rec := &record{indexes: []string{key}, block: entry.block, offset: int(entry.offset)}
+
+			if err = sorter.add("", rec); err != nil {
+				iter.Close()
+				return err
+			}
+		}
+	}
+
+	return iter.Close()
+}
+
+func (c *spaceCursor) next() (*record, error) {
+	for {
+		if c.events == nil {
+			if !c.sections.Next() || !strings.HasPrefix(string(c.sections.Key()), "g") {
+				return nil, c.sections.Close()
+			}
+
+			c.name = string(c.sections.Key())
+			c.sec = decodeSection(c.sections.Value())
+			c.events = sectionBlocks(c.space, c.sec)
+		}
+
+		location := entryLocation(c.events, c.sec)
+
+		event, err := pullEvent(c.events, c.space.header)
+		if err != nil {
+			return nil, fmt.Errorf("esdb: space %q: grouping %q: %v", c.space.Id, c.name[1:], err)
+		}
+
+		if event == nil {
+			c.events = nil
+			continue
+		}
+
+		rec := &record{
+			section:   c.name,
+			timestamp: event.Timestamp * c.scale,
+			seq:       c.seq,
+			data:      event.Data,
+		}
+
+		c.seq += 1
+
+		// Skips any entries for locations without an event,
+		// which only files which don't verify could have.
+		for c.entry != nil && (c.entry.block < location.block || c.entry.block == location.block && int64(c.entry.offset) <= location.offset) {
+			if c.entry.block == location.block && int64(c.entry.offset) == location.offset {
+				rec.indexes = append(rec.indexes, c.entry.indexes...)
+			}
+
+			if c.entry, err = c.entries.next(); err != nil {
+				return nil, err
+			}
+		}
+
+		return rec, nil
+	}
+}
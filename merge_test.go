@@ -0,0 +1,217 @@
+package esdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Returns a copy of the visits with distinct timestamps, so the order
+// of events within groupings and indexes doesn't depend on which file
+// they're merged from.
+func distinctVisits(visits []visit, scale int) []visit {
+	distinct := make([]visit, len(visits))
+
+	for i, v := range visits {
+		v.Timestamp = (1400000000 + i*7%len(visits)) * scale
+		distinct[i] = v
+	}
+
+	return distinct
+}
+
+func TestMerge(t *testing.T) {
+	all := readVisits("testdata/ten_thousand_visits.csv", 10000)
+	visits := distinctVisits(all, 1)
+	os.MkdirAll("tmp/spill", 0755)
+
+	// Spaces are split across the files, and some
+	// spaces are only in some of the files.
+	parts := make([][]visit, 3)
+	for i, v := range visits {
+		part := i % 3
+		if v.City == "Kingsbridge" {
+			part = 1
+		}
+
+		parts[part] = append(parts[part], v)
+	}
+
+	srcs := make([]string, len(parts))
+	for i, part := range parts {
+		srcs[i] = "tmp/part" + strconv.Itoa(i) + ".esdb"
+		writeVisits(t, srcs[i], part, WriterOptions{}, "")
+	}
+
+	var tests = []struct {
+		options WriterOptions
+		scale   int
+	}{
+		{WriterOptions{}, 1},
+		{WriterOptions{MemoryLimit: 64 * 1024, TempDir: "tmp/spill"}, 1},
+		{WriterOptions{BlockSize: 65536}, 1},
+		{WriterOptions{Precision: Milliseconds}, 1000},
+	}
+
+	for i, test := range tests {
+		expected := writeVisits(t, "tmp/unmerged"+strconv.Itoa(i)+".esdb", distinctVisits(all, test.scale), test.options, "")
+
+		path := "tmp/merged" + strconv.Itoa(i) + ".esdb"
+
+		if err := Merge(path, srcs, test.options); err != nil {
+			t.Fatalf("Case %d: %v", i, err)
+		}
+
+		if found, _ := ioutil.ReadFile(path); !bytes.Equal(found, expected) {
+			t.Errorf("Case %d: merged file differs from unmerged file: %d bytes, wanted %d bytes", i, len(found), len(expected))
+		}
+
+		if report, err := Verify(path); err != nil || !report.Ok() {
+			t.Errorf("Case %d: merged file failed verification: %v %v", i, err, report)
+		}
+
+		if files, _ := ioutil.ReadDir("tmp/spill"); len(files) != 0 {
+			t.Errorf("Case %d: temporary files weren't removed: %d remaining", i, len(files))
+		}
+	}
+}
+
+func TestMergeSameTimestamps(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+
+	srcs := []string{"tmp/same0.esdb", "tmp/same1.esdb"}
+
+	for i, src := range srcs {
+		os.Remove(src)
+
+		w, _ := New(src)
+		w.Add([]byte("a"), []byte(strconv.Itoa(i)+"a"), 2, "g", map[string]string{"i": "1"})
+		w.Add([]byte("a"), []byte(strconv.Itoa(i)+"b"), 2, "h", map[string]string{"i": "1"})
+		w.Add([]byte("a"), []byte(strconv.Itoa(i)+"c"), 1, "g", nil)
+
+		if err := w.Write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.Remove("tmp/same.esdb")
+
+	if err := Merge("tmp/same.esdb", srcs, WriterOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open("tmp/same.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var tests = []struct {
+		found []string
+		want  []string
+	}{
+		{fetchSpaceGrouping(db, []byte("a"), "g"), []string{"0a", "1a", "0c", "1c"}},
+		{fetchSpaceGrouping(db, []byte("a"), "h"), []string{"0b", "1b"}},
+		{fetchSpaceIndex(db, []byte("a"), "i", "1"), []string{"0a", "0b", "1a", "1b"}},
+	}
+
+	for i, test := range tests {
+		if !reflect.DeepEqual(test.found, test.want) {
+			t.Errorf("Case %d: wanted: %v, found: %v", i, test.want, test.found)
+		}
+	}
+}
+
+// Writes events which begin on the boundaries of blocks,
+// where the writer records them at the end of the block
+// before, all in a grouping and an index.
+func writeBoundaryEvents(t *testing.T, path string) {
+	os.MkdirAll("tmp", 0755)
+	os.Remove(path)
+
+	w, err := NewWithOptions(path, WriterOptions{BlockSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		data := strconv.Itoa(i) + strings.Repeat("-", 125)
+		w.Add([]byte("a"), []byte(data), i, "g", map[string]string{"k": "v"})
+	}
+
+	if err = w.Write(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks the file at path has the same groupings
+// and indexes as the file written by writeBoundaryEvents.
+func compareBoundaryEvents(t *testing.T, name, path string, original *Db) {
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	expected := fetchSpaceGrouping(original, []byte("a"), "g")
+
+	if found := fetchSpaceGrouping(db, []byte("a"), "g"); len(found) != 10 || !reflect.DeepEqual(found, expected) {
+		t.Errorf("%s: wrong grouping: wanted %d events, found %d", name, len(expected), len(found))
+	}
+
+	if found := fetchSpaceIndex(db, []byte("a"), "k", "v"); len(found) != 10 || !reflect.DeepEqual(found, expected) {
+		t.Errorf("%s: wrong index: wanted %d events, found %d", name, len(expected), len(found))
+	}
+
+	if report, err := Verify(path); err != nil || !report.Ok() {
+		t.Errorf("%s: failed verification: %v %v", name, err, report)
+	}
+}
+
+func TestMergeBlockBoundaries(t *testing.T) {
+	writeBoundaryEvents(t, "tmp/boundaries.esdb")
+
+	original, err := Open("tmp/boundaries.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+
+	os.Remove("tmp/boundaries_merged.esdb")
+
+	if err = Merge("tmp/boundaries_merged.esdb", []string{"tmp/boundaries.esdb"}, WriterOptions{BlockSize: 256}); err != nil {
+		t.Fatal(err)
+	}
+
+	compareBoundaryEvents(t, "merged", "tmp/boundaries_merged.esdb", original)
+}
+
+func TestMergeErrors(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+	os.Remove("tmp/precise.esdb")
+	os.Remove("tmp/coarse.esdb")
+
+	w, _ := NewWithOptions("tmp/precise.esdb", WriterOptions{Precision: Milliseconds})
+	w.Add([]byte("a"), []byte("1"), 1, "g", nil)
+	w.Write()
+
+	if err := Merge("tmp/coarse.esdb", []string{"tmp/precise.esdb"}, WriterOptions{}); err == nil {
+		t.Errorf("Expected error merging into a coarser precision")
+	}
+
+	if err := Merge("tmp/coarse.esdb", []string{"tmp/missing.esdb"}, WriterOptions{}); err == nil {
+		t.Errorf("Expected error merging a missing file")
+	}
+
+	if _, err := os.Stat("tmp/coarse.esdb"); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be written, found: %v", err)
+	}
+
+	if err := Merge("tmp/precise.esdb", []string{"tmp/precise.esdb"}, WriterOptions{Precision: Milliseconds}); err == nil {
+		t.Errorf("Expected error merging into an existing file")
+	}
+}
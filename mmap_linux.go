@@ -0,0 +1,50 @@
+package esdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// Opens a .esdb file for reading by mapping it into memory, rather
+// than reading it with system calls. The mapping is shared by all
+// goroutines using the Db, and released by Close.
+//
+// SSTables, and the data of events in uncompressed blocks, are read
+// directly from the mapping without copying. So the Db, its spaces,
+// and the Data of any events read, mustn't be used after Close.
+func OpenMmap(path string) (*Db, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The mapping remains once the file is closed.
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := stat.Size()
+
+	// Empty files can't be mapped.
+	if size == 0 {
+		return nil, ErrNotEsdb
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openReader(mapping(data), size, nil)
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+
+	db.unmap = func() error { return syscall.Munmap(data) }
+
+	return db, nil
+}
@@ -0,0 +1,103 @@
+package esdb
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/customerio/esdb/blocks"
+)
+
+func TestOpenMmap(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+
+	for i, codec := range []blocks.Codec{blocks.Snappy, blocks.NoCompression} {
+		path := "tmp/mmap" + strconv.Itoa(i) + ".esdb"
+		writeVisits(t, path, visits, WriterOptions{Codec: codec}, "")
+
+		db, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := fetchSpaceIndex(db, []byte("Kingsbridge"), "type", "visit")
+		db.Close()
+
+		mapped, err := OpenMmap(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found := fetchSpaceIndex(mapped, []byte("Kingsbridge"), "type", "visit"); len(found) == 0 || !reflect.DeepEqual(found, expected) {
+			t.Errorf("Case %d: wrong events: wanted %d events, found %d", i, len(expected), len(found))
+		}
+
+		count, err := mapped.Find([]byte("Kingsbridge")).CountIndex("type", "visit")
+		if count != len(expected) || err != nil {
+			t.Errorf("Case %d: wrong count: want: %d,<nil> got: %d,%v", i, len(expected), count, err)
+		}
+
+		// The data of events in uncompressed blocks isn't copied.
+		iter := mapped.Find([]byte("Kingsbridge")).Events("")
+		data := mapped.reader.(mapping)
+		copied := 0
+
+		for iter.Next() {
+			if !within(iter.Event().Data, data) {
+				copied += 1
+			}
+		}
+
+		if err = iter.Close(); err != nil {
+			t.Errorf("Case %d: failed iteration: %v", i, err)
+		}
+
+		if codec == blocks.NoCompression && copied > 0 {
+			t.Errorf("Case %d: expected events not to be copied, found %d copies", i, copied)
+		}
+
+		mapped.Close()
+
+		if mapped.unmap != nil {
+			t.Errorf("Case %d: expected file to be unmapped", i)
+		}
+	}
+}
+
+func TestConcurrentMmapScans(t *testing.T) {
+	TestConcurrentSpaceScans(t)
+
+	db, err := OpenMmap("tmp/concurrent.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	scanConcurrently(t, db)
+}
+
+func TestOpenMmapNotEsdb(t *testing.T) {
+	ioutil.WriteFile("tmp/empty.esdb", nil, 0644)
+	ioutil.WriteFile("tmp/text.esdb", []byte(strings.Repeat("not an esdb file", 10)), 0644)
+
+	for _, path := range []string{"tmp/empty.esdb", "tmp/text.esdb"} {
+		if _, err := OpenMmap(path); err != ErrNotEsdb {
+			t.Errorf("%s: wrong error: want: %v got: %v", path, ErrNotEsdb, err)
+		}
+	}
+}
+
+// Returns true if b is a slice of data.
+func within(b, data []byte) bool {
+	if len(b) == 0 {
+		return true
+	}
+
+	start := uintptr(unsafe.Pointer(&data[0]))
+	p := uintptr(unsafe.Pointer(&b[0]))
+
+	return p >= start && p < start+uintptr(len(data))
+}
@@ -0,0 +1,67 @@
+package esdb
+
+import (
+	"time"
+)
+
+// Precision is the unit of the timestamps of events within a file.
+// Files written before the precision was configurable, or with the
+// default precision, have timestamps in seconds.
+type Precision int
+
+const (
+	Seconds Precision = iota
+	Milliseconds
+	Microseconds
+	Nanoseconds
+)
+
+// Returns the length of a single unit of the precision.
+func (p Precision) Duration() time.Duration {
+	switch p {
+	case Milliseconds:
+		return time.Millisecond
+	case Microseconds:
+		return time.Microsecond
+	case Nanoseconds:
+		return time.Nanosecond
+	default:
+		return time.Second
+	}
+}
+
+// Converts a timestamp with the precision to a time.
+func (p Precision) Time(timestamp int) time.Time {
+	unit := p.Duration()
+	perSecond := int64(time.Second / unit)
+
+	return time.Unix(int64(timestamp)/perSecond, int64(timestamp)%perSecond*int64(unit))
+}
+
+// Converts a time to a timestamp with the precision,
+// discarding anything more precise.
+func (p Precision) Timestamp(t time.Time) int {
+	unit := p.Duration()
+	perSecond := int64(time.Second / unit)
+
+	return int(t.Unix()*perSecond + int64(t.Nanosecond())/int64(unit))
+}
+
+func (p Precision) valid() bool {
+	return p >= Seconds && p <= Nanoseconds
+}
+
+func (p Precision) String() string {
+	switch p {
+	case Seconds:
+		return "seconds"
+	case Milliseconds:
+		return "milliseconds"
+	case Microseconds:
+		return "microseconds"
+	case Nanoseconds:
+		return "nanoseconds"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,110 @@
+package esdb
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrecisionConversion(t *testing.T) {
+	var tests = []struct {
+		precision Precision
+		timestamp int
+		time      time.Time
+	}{
+		{Seconds, 1403534919, time.Unix(1403534919, 0)},
+		{Seconds, -86400, time.Unix(-86400, 0)},
+		{Seconds, 5000000000, time.Unix(5000000000, 0)},
+		{Milliseconds, 1403534919123, time.Unix(1403534919, 123000000)},
+		{Milliseconds, -1500, time.Unix(-2, 500000000)},
+		{Microseconds, 1403534919123456, time.Unix(1403534919, 123456000)},
+		{Nanoseconds, 1403534919123456789, time.Unix(1403534919, 123456789)},
+	}
+
+	for i, test := range tests {
+		if found := test.precision.Time(test.timestamp); !found.Equal(test.time) {
+			t.Errorf("Case #%d: wrong time: wanted: %v found: %v", i, test.time, found)
+		}
+
+		if found := test.precision.Timestamp(test.time); found != test.timestamp {
+			t.Errorf("Case #%d: wrong timestamp: wanted: %d found: %d", i, test.timestamp, found)
+		}
+	}
+}
+
+func TestWidePreciseTimestamps(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+	os.Remove("tmp/precise.esdb")
+
+	w, err := NewWithOptions("tmp/precise.esdb", WriterOptions{Precision: Milliseconds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Unix(1403534919, 0)
+
+	// Events within the same second, before the epoch,
+	// and beyond the range of 32 bit timestamps.
+	w.AddTime([]byte("a"), []byte("1"), base, "g", nil)
+	w.AddTime([]byte("a"), []byte("2"), base.Add(1*time.Millisecond), "g", nil)
+	w.AddTime([]byte("a"), []byte("3"), base.Add(999*time.Millisecond), "g", nil)
+	w.AddTime([]byte("a"), []byte("4"), time.Unix(-86400, 0), "g", nil)
+	w.AddTime([]byte("a"), []byte("5"), time.Unix(5000000000, 250000000), "g", nil)
+
+	if err := w.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open("tmp/precise.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.Precision() != Milliseconds {
+		t.Errorf("Wrong precision: wanted: %v found: %v", Milliseconds, db.Precision())
+	}
+
+	space := db.Find([]byte("a"))
+
+	found := make([]string, 0)
+	times := make([]time.Time, 0)
+
+	space.Scan("g", func(e *Event) bool {
+		found = append(found, string(e.Data))
+		times = append(times, e.Time())
+		return true
+	})
+
+	if want := []string{"5", "3", "2", "1", "4"}; !reflect.DeepEqual(found, want) {
+		t.Errorf("Wrong event order: wanted: %v found: %v", want, found)
+	}
+
+	if want := time.Unix(5000000000, 250000000); !times[0].Equal(want) {
+		t.Errorf("Wrong event time: wanted: %v found: %v", want, times[0])
+	}
+
+	if want := time.Unix(-86400, 0); !times[4].Equal(want) {
+		t.Errorf("Wrong event time: wanted: %v found: %v", want, times[4])
+	}
+
+	from := Milliseconds.Timestamp(base.Add(1 * time.Millisecond))
+	to := Milliseconds.Timestamp(base.Add(999 * time.Millisecond))
+
+	found = found[:0]
+	space.ScanRange("g", from, to, func(e *Event) bool {
+		found = append(found, string(e.Data))
+		return true
+	})
+
+	if want := []string{"3", "2"}; !reflect.DeepEqual(found, want) {
+		t.Errorf("Wrong events in range: wanted: %v found: %v", want, found)
+	}
+}
+
+func TestInvalidPrecision(t *testing.T) {
+	if _, err := NewWithOptions("tmp/invalid.esdb", WriterOptions{Precision: Precision(7)}); err == nil {
+		t.Errorf("Expected error for invalid precision")
+	}
+}
@@ -0,0 +1,457 @@
+package esdb
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/customerio/esdb/blocks"
+)
+
+var ErrUnboundedQuery = errors.New("esdb: Not queries must be combined with another query using And")
+
+// A Query selects the events of a space by their secondary indexes,
+// combining the entries of each index rather than decoding events,
+// so only the events which match are read.
+//
+//	// type=purchase AND country=US
+//	esdb.And(esdb.Eq("type", "purchase"), esdb.Eq("country", "US"))
+//
+//	// type IN (click, purchase)
+//	esdb.Or(esdb.Eq("type", "click"), esdb.Eq("type", "purchase"))
+//
+//	// type=purchase AND NOT country=US
+//	esdb.And(esdb.Eq("type", "purchase"), esdb.Not(esdb.Eq("country", "US")))
+//
+// As there's no index of every event within a space, Not can only
+// be used within an And which has at least one query without a Not.
+//
+// Events are matched newest first. Events with the same timestamp
+// are matched in the order they're located in the space.
+type Query interface {
+	// Returns the entries of the events matching the query with
+	// timestamps between from and to (inclusive).
+	postings(s *Space, from, to int) (postings, error)
+}
+
+type eqQuery struct {
+	name  string
+	value string
+}
+
+// Matches the events indexed with the given value.
+func Eq(name, value string) Query {
+	return &eqQuery{name, value}
+}
+
+func (q *eqQuery) postings(s *Space, from, to int) (postings, error) {
+	sec, err := s.findSection("i" + q.name + ":" + q.value)
+	if sec == nil {
+		return &indexPostings{done: true}, err
+	}
+
+	index := s.newSectionReader(sec)
+
+	if err = s.seek(index, sec, to); err != nil {
+		return nil, err
+	}
+
+	return &indexPostings{
+		space: s,
+		index: index,
+		from:  from,
+		to:    to,
+	}, nil
+}
+
+type andQuery struct {
+	queries []Query
+}
+
+// Matches the events matched by all of the queries.
+func And(queries ...Query) Query {
+	return &andQuery{queries}
+}
+
+func (q *andQuery) postings(s *Space, from, to int) (postings, error) {
+	p := &andPostings{}
+
+	for _, query := range q.queries {
+		if not, ok := query.(*notQuery); ok {
+			excluded, err := not.query.postings(s, from, to)
+			if err != nil {
+				return nil, err
+			}
+
+			p.exclude = append(p.exclude, &peekedPostings{postings: excluded})
+		} else {
+			included, err := query.postings(s, from, to)
+			if err != nil {
+				return nil, err
+			}
+
+			p.include = append(p.include, &peekedPostings{postings: included})
+		}
+	}
+
+	if len(p.include) == 0 {
+		return nil, ErrUnboundedQuery
+	}
+
+	return p, nil
+}
+
+type orQuery struct {
+	queries []Query
+}
+
+// Matches the events matched by any of the queries.
+func Or(queries ...Query) Query {
+	return &orQuery{queries}
+}
+
+func (q *orQuery) postings(s *Space, from, to int) (postings, error) {
+	p := &orPostings{}
+
+	for _, query := range q.queries {
+		included, err := query.postings(s, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		p.include = append(p.include, &peekedPostings{postings: included})
+	}
+
+	return p, nil
+}
+
+type notQuery struct {
+	query Query
+}
+
+// Excludes the events matched by the query from an And.
+func Not(query Query) Query {
+	return &notQuery{query}
+}
+
+func (q *notQuery) postings(s *Space, from, to int) (postings, error) {
+	return nil, ErrUnboundedQuery
+}
+
+// Postings step through the entries of the events matched by a query,
+// newest first, and then by location for events with the same
+// timestamp, so postings can be combined by stepping through them
+// together.
+type postings interface {
+	// Returns the next entry, or nil once there are no more.
+	next() (*indexEntry, error)
+}
+
+// Returns true if the entry comes before the other in postings.
+func (e *indexEntry) precedes(other *indexEntry) bool {
+	if e.timestamp != other.timestamp {
+		return e.timestamp > other.timestamp
+	}
+
+	if e.block != other.block {
+		return e.block < other.block
+	}
+
+	return e.offset < other.offset
+}
+
+func (e *indexEntry) locatedAt(other *indexEntry) bool {
+	return e.block == other.block && e.offset == other.offset
+}
+
+// The entries of a single index.
+//
+// Entries with the same timestamp are stored in the order their
+// events were added, so they're read together and sorted by location.
+type indexPostings struct {
+	space *Space
+	index *blocks.Reader
+
+	// Used to read timestamps of events for files
+	// without timestamps in their index entries.
+	events *blocks.Reader
+
+	from    int
+	to      int
+	pending []*indexEntry
+	ahead   *indexEntry
+	done    bool
+}
+
+func (p *indexPostings) next() (*indexEntry, error) {
+	if len(p.pending) == 0 {
+		if err := p.fill(); err != nil {
+			return nil, err
+		}
+
+		if len(p.pending) == 0 {
+			return nil, nil
+		}
+	}
+
+	entry := p.pending[0]
+	p.pending = p.pending[1:]
+
+	return entry, nil
+}
+
+// Reads all of the entries with the next timestamp within the range.
+func (p *indexPostings) fill() error {
+	for {
+		entry := p.ahead
+		p.ahead = nil
+
+		if entry == nil {
+			if p.done {
+				break
+			}
+
+			var err error
+			if entry, err = p.pull(); err != nil {
+				return err
+			}
+
+			// Entries are stored newest first, so once
+			// we're past the range there's nothing left.
+			if entry == nil || entry.timestamp < p.from {
+				p.done = true
+				break
+			}
+
+			if entry.timestamp > p.to {
+				continue
+			}
+		}
+
+		if len(p.pending) > 0 && entry.timestamp != p.pending[0].timestamp {
+			p.ahead = entry
+			break
+		}
+
+		p.pending = append(p.pending, entry)
+	}
+
+	sort.Slice(p.pending, func(i, j int) bool { return p.pending[i].precedes(p.pending[j]) })
+
+	return nil
+}
+
+func (p *indexPostings) pull() (*indexEntry, error) {
+	entry, err := pullIndexEntry(p.index, p.space.header)
+	if err != nil || entry == nil || p.space.header.indexTimestamps() {
+		return entry, err
+	}
+
+	if p.events == nil {
+		p.events = p.space.newReader()
+	}
+
+	return entry, p.space.readEntryTimestamp(p.events, entry)
+}
+
+// Postings which can be looked at before stepping past the entry.
+type peekedPostings struct {
+	postings postings
+	entry    *indexEntry
+	peeked   bool
+}
+
+func (p *peekedPostings) peek() (*indexEntry, error) {
+	if !p.peeked {
+		entry, err := p.postings.next()
+		if err != nil {
+			return nil, err
+		}
+
+		p.entry, p.peeked = entry, true
+	}
+
+	return p.entry, nil
+}
+
+func (p *peekedPostings) advance() {
+	p.peeked = false
+}
+
+// Steps past all entries preceding the target, and returns the next.
+func (p *peekedPostings) seek(target *indexEntry) (*indexEntry, error) {
+	for {
+		entry, err := p.peek()
+		if err != nil || entry == nil || !entry.precedes(target) {
+			return entry, err
+		}
+
+		p.advance()
+	}
+}
+
+// The entries in all of the included postings,
+// and none of the excluded postings.
+type andPostings struct {
+	include []*peekedPostings
+	exclude []*peekedPostings
+}
+
+func (p *andPostings) next() (*indexEntry, error) {
+	for {
+		candidate, err := p.include[0].peek()
+		if err != nil || candidate == nil {
+			return nil, err
+		}
+
+		matched, err := p.intersects(candidate)
+		if err != nil || matched == nil {
+			return nil, err
+		}
+
+		// Another postings' next entry is past the candidate,
+		// so there's nothing to match before that entry.
+		if !matched.locatedAt(candidate) {
+			if _, err = p.include[0].seek(matched); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		for _, included := range p.include {
+			included.advance()
+		}
+
+		excluded, err := p.excludes(candidate)
+		if err != nil {
+			return nil, err
+		}
+
+		if !excluded {
+			return candidate, nil
+		}
+	}
+}
+
+// Moves each included postings to the candidate, returning the
+// candidate if they all include it, or the first entry found past
+// it otherwise, or nil if any of the postings run out.
+func (p *andPostings) intersects(candidate *indexEntry) (*indexEntry, error) {
+	for _, included := range p.include[1:] {
+		entry, err := included.seek(candidate)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+
+		if !entry.locatedAt(candidate) {
+			return entry, nil
+		}
+	}
+
+	return candidate, nil
+}
+
+func (p *andPostings) excludes(candidate *indexEntry) (bool, error) {
+	for _, excluded := range p.exclude {
+		entry, err := excluded.seek(candidate)
+		if err != nil {
+			return false, err
+		}
+
+		if entry != nil && entry.locatedAt(candidate) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// The entries in any of the included postings.
+type orPostings struct {
+	include []*peekedPostings
+}
+
+func (p *orPostings) next() (*indexEntry, error) {
+	var first *indexEntry
+
+	for _, included := range p.include {
+		entry, err := included.peek()
+		if err != nil {
+			return nil, err
+		}
+
+		if entry != nil && (first == nil || entry.precedes(first)) {
+			first = entry
+		}
+	}
+
+	if first == nil {
+		return nil, nil
+	}
+
+	// Events in several of the postings are only matched once.
+	for _, included := range p.include {
+		if entry, _ := included.peek(); entry != nil && entry.locatedAt(first) {
+			included.advance()
+		}
+	}
+
+	return first, nil
+}
+
+// Steps through the events matched by a query.
+type queryIterator struct {
+	space    *Space
+	postings postings
+	events   *blocks.Reader
+	event    *Event
+	err      error
+	done     bool
+}
+
+func (i *queryIterator) Next() bool {
+	if !i.done {
+		i.event, i.err = i.pull()
+
+		if i.err == nil && i.event != nil {
+			return true
+		}
+	}
+
+	i.Close()
+	return false
+}
+
+func (i *queryIterator) Event() *Event {
+	return i.event
+}
+
+func (i *queryIterator) Err() error {
+	return i.err
+}
+
+func (i *queryIterator) Close() error {
+	i.done = true
+	i.event = nil
+	return i.err
+}
+
+func (i *queryIterator) pull() (*Event, error) {
+	entry, err := i.postings.next()
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	// Move to the event's location within its block.
+	if err = i.space.seekBlock(i.events, entry.block, entry.offset); err != nil {
+		return nil, err
+	}
+
+	event, err := pullEvent(i.events, i.space.header)
+	if err == nil && event == nil {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return event, err
+}
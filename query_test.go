@@ -0,0 +1,118 @@
+package esdb
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func createQueried(header fileHeader, count int) *Space {
+	buffer := bytes.NewBuffer([]byte{})
+	writer := newSpace(buffer, header, []byte("a"))
+
+	// Several events share each timestamp, and there are enough
+	// events for the indexes to span several blocks.
+	for i := 0; i < count; i++ {
+		writer.add(newEvent([]byte(strconv.Itoa(i)), i/4), "g", map[string]string{
+			"mod2": strconv.Itoa(i % 2),
+			"mod3": strconv.Itoa(i % 3),
+			"mod5": strconv.Itoa(i % 5),
+		})
+	}
+
+	writer.write()
+
+	return openSpace(bytes.NewReader(buffer.Bytes()), header, nil, []byte("a"), 0, int64(buffer.Len()))
+}
+
+// The events matching the predicate, in the order they're stored in
+// the space's only grouping.
+func expectQueried(count, from, to int, match func(i int) bool) []string {
+	found := make([]int, 0)
+
+	for i := 0; i < count; i++ {
+		if i/4 >= from && i/4 <= to && match(i) {
+			found = append(found, i)
+		}
+	}
+
+	sort.SliceStable(found, func(a, b int) bool { return found[a]/4 > found[b]/4 })
+
+	want := make([]string, len(found))
+	for j, i := range found {
+		want[j] = strconv.Itoa(i)
+	}
+
+	return want
+}
+
+func TestSpaceQueryScanning(t *testing.T) {
+	count := 3000
+
+	withoutTimestamps := currentHeader()
+	withoutTimestamps.flags &^= FLAG_INDEX_TIMESTAMPS
+
+	var tests = []struct {
+		query Query
+		match func(i int) bool
+	}{
+		{Eq("mod2", "0"), func(i int) bool { return i%2 == 0 }},
+		{Eq("mod2", "missing"), func(i int) bool { return false }},
+		{And(Eq("mod2", "0"), Eq("mod3", "0")), func(i int) bool { return i%2 == 0 && i%3 == 0 }},
+		{And(Eq("mod2", "1"), Eq("mod3", "2"), Eq("mod5", "4")), func(i int) bool { return i%2 == 1 && i%3 == 2 && i%5 == 4 }},
+		{And(Eq("mod2", "0"), Eq("mod2", "missing")), func(i int) bool { return false }},
+		{Or(Eq("mod3", "0"), Eq("mod5", "0")), func(i int) bool { return i%3 == 0 || i%5 == 0 }},
+		{Or(Eq("mod3", "missing"), Eq("mod5", "0")), func(i int) bool { return i%5 == 0 }},
+		{And(Eq("mod2", "0"), Not(Eq("mod3", "0"))), func(i int) bool { return i%2 == 0 && i%3 != 0 }},
+		{And(Eq("mod2", "0"), Not(Eq("mod3", "missing"))), func(i int) bool { return i%2 == 0 }},
+		{
+			And(Or(Eq("mod3", "1"), Eq("mod5", "1")), Not(Eq("mod2", "1")), Not(Eq("mod5", "3"))),
+			func(i int) bool { return (i%3 == 1 || i%5 == 1) && i%2 != 1 && i%5 != 3 },
+		},
+	}
+
+	for _, header := range []fileHeader{currentHeader(), withoutTimestamps, legacyHeader()} {
+		space := createQueried(header, count)
+
+		for i, test := range tests {
+			for _, bounds := range [][2]int{{0, count}, {100, 300}} {
+				from, to := bounds[0], bounds[1]
+				want := expectQueried(count, from, to, test.match)
+
+				found := make([]string, 0)
+
+				err := space.ScanQueryRange(test.query, from, to, func(e *Event) bool {
+					found = append(found, string(e.Data))
+					return true
+				})
+
+				if err != nil {
+					t.Errorf("Flags %d case #%d: unexpected error: %v", header.flags, i, err)
+				}
+
+				if !reflect.DeepEqual(found, want) {
+					t.Errorf("Flags %d case #%d (%d-%d): wrong events: wanted %d events, found %d:\n wanted: %.80v\n found:  %.80v", header.flags, i, from, to, len(want), len(found), want, found)
+				}
+			}
+		}
+	}
+}
+
+func TestSpaceUnboundedQuery(t *testing.T) {
+	space := createQueried(currentHeader(), 10)
+
+	var tests = []Query{
+		Not(Eq("mod2", "0")),
+		And(Not(Eq("mod2", "0"))),
+		And(),
+		Or(Eq("mod2", "0"), Not(Eq("mod3", "0"))),
+	}
+
+	for i, query := range tests {
+		if err := space.ScanQuery(query, func(e *Event) bool { return true }); err != ErrUnboundedQuery {
+			t.Errorf("Case #%d: wrong error: wanted: %v found: %v", i, ErrUnboundedQuery, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package esdb
+
+import (
+	"io"
+	"math"
+)
+
+// Steps through the events of a grouping or index oldest first.
+//
+// Sections are stored newest first, so the section's summary is
+// used to walk its blocks backwards. Each block's events are read
+// forwards, buffered, and returned in reverse, so only a single
+// block's worth of events is held in memory at a time.
+//
+// Sections of files written before summaries were introduced are
+// read forwards once to build their summary, which holds a single
+// entry for each block, before walking the blocks backwards.
+type reverseIterator struct {
+	space    *Space
+	summary  summary
+	index    bool
+	segment  int
+	buffered []*Event
+	event    *Event
+	err      error
+	done     bool
+}
+
+func (s *Space) newReverseIterator(sec *section, index bool) EventIterator {
+	sum, err := s.readSummary(sec)
+	if err == nil && sec.summaryLen == 0 {
+		sum, err = s.scanSummary(sec, index)
+	}
+
+	if err != nil {
+		return emptyIterator(err)
+	}
+
+	return &reverseIterator{
+		space:   s,
+		summary: sum,
+		index:   index,
+		segment: len(sum) - 1,
+	}
+}
+
+func (i *reverseIterator) Next() bool {
+	for !i.done {
+		if n := len(i.buffered); n > 0 {
+			i.event = i.buffered[n-1]
+			i.buffered = i.buffered[:n-1]
+			return true
+		}
+
+		if i.segment < 0 {
+			break
+		}
+
+		if i.err = i.load(i.summary[i.segment]); i.err != nil {
+			break
+		}
+
+		i.segment -= 1
+	}
+
+	i.Close()
+	return false
+}
+
+func (i *reverseIterator) Event() *Event {
+	return i.event
+}
+
+func (i *reverseIterator) Err() error {
+	return i.err
+}
+
+func (i *reverseIterator) Close() error {
+	i.done = true
+	i.event = nil
+	i.buffered = nil
+	return i.err
+}
+
+// Reads all events starting in the summarized block.
+func (i *reverseIterator) load(entry summaryEntry) error {
+	reader := i.space.newReader()
+
+	iter := &eventIterator{
+		space:  i.space,
+		header: i.space.header,
+		events: reader,
+		from:   math.MinInt64,
+		to:     math.MaxInt64,
+	}
+
+	if i.index {
+		iter.events = i.space.newReader()
+		iter.index = reader
+	}
+
+	if err := i.space.seekBlock(reader, entry.block, int64(entry.offset)); err != nil {
+		return err
+	}
+
+	for j := 0; j < entry.count; j++ {
+		event, err := iter.pull()
+		if err != nil {
+			return err
+		}
+
+		if event == nil {
+			return io.ErrUnexpectedEOF
+		}
+
+		i.buffered = append(i.buffered, event)
+	}
+
+	return nil
+}
+
+// Builds the summary of a section written without one, reading
+// through the section's entries from the newest to the oldest.
+func (s *Space) scanSummary(sec *section, index bool) (summary, error) {
+	r := sectionBlocks(s, sec)
+	sum := make(summary, 0)
+
+	for {
+		location := entryLocation(r, sec)
+		timestamp := 0
+
+		if index {
+			entry, err := pullIndexEntry(r, s.header)
+			if err != nil {
+				return nil, err
+			}
+
+			if entry == nil {
+				break
+			}
+
+			timestamp = entry.timestamp
+		} else {
+			event, err := pullEvent(r, s.header)
+			if err != nil {
+				return nil, err
+			}
+
+			if event == nil {
+				break
+			}
+
+			timestamp = event.Timestamp
+		}
+
+		sum.add(location.block, int(location.offset), timestamp)
+	}
+
+	return sum, nil
+}
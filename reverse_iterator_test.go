@@ -0,0 +1,113 @@
+package esdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpaceReverseScanning(t *testing.T) {
+	space := create([]byte("a"))
+
+	var tests = []struct {
+		iter EventIterator
+		want []string
+	}{
+		{space.RevEvents("a"), []string{"1"}},
+		{space.RevEvents("b"), []string{"3", "2"}},
+		{space.RevEvents("c"), []string{}},
+		{space.RevIndexEvents("ts", ""), []string{"3", "1", "2"}},
+		{space.RevIndexEvents("i", "i1"), []string{"3", "1"}},
+		{space.RevIndexEvents("i", "i3"), []string{}},
+	}
+
+	for i, test := range tests {
+		found := make([]string, 0)
+
+		for test.iter.Next() {
+			found = append(found, string(test.iter.Event().Data))
+		}
+
+		if err := test.iter.Err(); err != nil {
+			t.Errorf("Case #%v: unexpected error: %v", i, err)
+		}
+
+		if !reflect.DeepEqual(test.want, found) {
+			t.Errorf("Case #%v: wanted: %v, found: %v", i, test.want, found)
+		}
+	}
+}
+
+func TestSpaceReverseScanningBlocks(t *testing.T) {
+	space := createLarge(1000)
+
+	ts := 0
+
+	err := space.RevScan("", func(e *Event) bool {
+		if e.Timestamp != ts {
+			t.Fatalf("Wrong event: wanted: %d, found: %d", ts, e.Timestamp)
+		}
+		ts += 1
+		return true
+	})
+
+	if err != nil || ts != 1000 {
+		t.Errorf("Wrong scan result: wanted: 1000,<nil> found: %d,%v", ts, err)
+	}
+
+	ts = 1
+
+	err = space.RevScanIndex("even", "false", func(e *Event) bool {
+		if e.Timestamp != ts {
+			t.Fatalf("Wrong indexed event: wanted: %d, found: %d", ts, e.Timestamp)
+		}
+		ts += 2
+		return ts < 500
+	})
+
+	if err != nil || ts != 501 {
+		t.Errorf("Wrong index scan result: wanted: 501,<nil> found: %d,%v", ts, err)
+	}
+}
+
+func TestReverseScanWithoutSummary(t *testing.T) {
+	space := createLarge(1000)
+
+	var tests = []struct {
+		key   string
+		index bool
+	}{
+		{"g", false},
+		{"ieven:false", true},
+		{"ieven:true", true},
+	}
+
+	for i, test := range tests {
+		sec, _ := space.findSection(test.key)
+
+		expected := make([]string, 0)
+		for iter := space.newReverseIterator(sec, test.index); iter.Next(); {
+			expected = append(expected, string(iter.Event().Data))
+		}
+
+		// The summary found by reading the section is the one written.
+		stored, _ := space.readSummary(sec)
+
+		if found, err := space.scanSummary(sec, test.index); err != nil || !reflect.DeepEqual(found, stored) {
+			t.Errorf("Case #%d: wrong summary: wanted: %v found: %v %v", i, stored, found, err)
+		}
+
+		// Simulate a file written before section summaries existed.
+		sec.summaryLen = 0
+
+		iter := space.newReverseIterator(sec, test.index)
+		found := make([]string, 0)
+
+		for iter.Next() {
+			found = append(found, string(iter.Event().Data))
+		}
+
+		if err := iter.Err(); err != nil || len(found) < 500 || !reflect.DeepEqual(found, expected) {
+			t.Errorf("Case #%d: wrong events without a summary: wanted %d events, found %d: %v", i, len(expected), len(found), err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package esdb
+
+// RewriteEvent is an event of a file being rewritten, along with
+// the grouping and indexes it's in.
+type RewriteEvent struct {
+	Data []byte
+
+	// The event's timestamp, in units of the file's precision.
+	Timestamp int
+
+	Grouping string
+	Indexes  map[string]string
+}
+
+// A RewriteFilter is called with each event of each space of a file
+// being rewritten, returning false to drop the event. The event can
+// be modified to replace it, such as to redact its data, or to remove
+// it from some of its indexes.
+type RewriteFilter func(spaceId []byte, event *RewriteEvent) bool
+
+// Rewrites the ESDB file at src to dst, with only the events the filter
+// keeps, such as to erase the events of a customer. The groupings and
+// indexes of each space are rebuilt from the events which are kept,
+// and spaces without any events are dropped.
+//
+// The new file has the same format settings as src, other than being
+// written in the current version of the format. It's written to a
+// temporary file next to dst, which is renamed to dst once it's been
+// written, so dst can be src to rewrite a file in place. Events are
+// read from src as they're written, so only a limited amount of events
+// are held in memory, with the rest sorted in temporary files.
+func Rewrite(src, dst string, filter RewriteFilter) (err error) {
+	db, err := Open(src)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stat, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := createFile(dst, WriterOptions{
+		Precision:   db.header.precision,
+		Codec:       db.header.codec,
+		BlockSize:   db.header.blockSize,
+		MemoryLimit: defaultMergeMemoryLimit,
+	}, stat.Mode(), true)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			w.Abort()
+		}
+	}()
+
+	ids, err := newSpaceMerger([]*Db{db})
+	if err != nil {
+		return err
+	}
+
+	for {
+		id, spaces, err := ids.next()
+		if err != nil {
+			return err
+		}
+
+		if spaces == nil {
+			break
+		}
+
+		if err = w.writeRewrittenSpace(id, spaces[0], filter); err != nil {
+			return err
+		}
+	}
+
+	return w.Write()
+}
+
+// Writes a space with only the events the filter keeps. As the filter
+// can change the events' timestamps and groupings, they're sorted
+// again, as when writing a space with a MemoryLimit.
+func (w *Writer) writeRewrittenSpace(id string, space *Space, filter RewriteFilter) error {
+	// The memory limit is shared by the sorts of the space's
+	// index entries as they're read and as they're written,
+	// and of the space's events.
+	limit := w.options.MemoryLimit / 3
+
+	entries := newRecordSorter(limit, w.options.TempDir)
+	defer entries.close()
+
+	events := newRecordSorter(limit, w.options.TempDir)
+	defer events.close()
+
+	cursor, err := newSpaceCursor(space, w.header.precision, 0, entries)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rec, err := cursor.next()
+		if err != nil {
+			return err
+		}
+
+		if rec == nil {
+			break
+		}
+
+		event := &RewriteEvent{
+			Data:      rec.data,
+			Timestamp: rec.timestamp,
+			Grouping:  rec.section[1:],
+			Indexes:   make(map[string]string, len(rec.indexes)),
+		}
+
+		for _, key := range rec.indexes {
+			name, value := splitIndexKey(key)
+			event.Indexes[name] = value
+		}
+
+		if !filter(space.Id, event) {
+			continue
+		}
+
+		rec = &record{
+			section:   "g" + event.Grouping,
+			timestamp: event.Timestamp,
+			seq:       rec.seq,
+			data:      event.Data,
+			indexes:   make([]string, 0, len(event.Indexes)),
+		}
+
+		for name, value := range event.Indexes {
+			rec.indexes = append(rec.indexes, "i"+name+":"+value)
+		}
+
+		if err = events.add(id, rec); err != nil {
+			return err
+		}
+	}
+
+	if !events.has(id) {
+		return nil
+	}
+
+	return w.writeRecords(id, events.take(id), limit)
+}
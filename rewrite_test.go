@@ -0,0 +1,157 @@
+package esdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	visits := distinctVisits(readVisits("testdata/ten_thousand_visits.csv", 10000), 1)
+	original := writeVisits(t, "tmp/original.esdb", visits, WriterOptions{}, "")
+
+	// Rewriting every event as it is rewrites the same file.
+	if err := Rewrite("tmp/original.esdb", "tmp/kept.esdb", func(id []byte, e *RewriteEvent) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := ioutil.ReadFile("tmp/kept.esdb"); !bytes.Equal(found, original) {
+		t.Errorf("Rewritten file differs from the original: %d bytes, wanted %d bytes", len(found), len(original))
+	}
+
+	erased, redacted := visits[0].Visitor, visits[1].Visitor
+
+	filter := func(id []byte, e *RewriteEvent) bool {
+		if string(id) == "Kingsbridge" || e.Indexes["visitor"] == erased {
+			return false
+		}
+
+		if e.Indexes["visitor"] == redacted {
+			e.Data = []byte("redacted")
+			delete(e.Indexes, "visitor")
+		}
+
+		return true
+	}
+
+	if err := Rewrite("tmp/original.esdb", "tmp/rewritten.esdb", filter); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file written with only the events the filter keeps.
+	w, _ := New("tmp/filtered.esdb")
+
+	for _, v := range visits {
+		indexes := map[string]string{"visitor": v.Visitor, "type": v.EventType}
+		e := &RewriteEvent{Data: v.data, Timestamp: v.Timestamp, Grouping: v.Host, Indexes: indexes}
+
+		if filter([]byte(v.City), e) {
+			w.Add([]byte(v.City), e.Data, e.Timestamp, e.Grouping, e.Indexes)
+		}
+	}
+
+	if err := w.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := ioutil.ReadFile("tmp/filtered.esdb")
+
+	if found, _ := ioutil.ReadFile("tmp/rewritten.esdb"); !bytes.Equal(found, expected) {
+		t.Errorf("Rewritten file differs from the filtered file: %d bytes, wanted %d bytes", len(found), len(expected))
+	}
+
+	if report, err := Verify("tmp/rewritten.esdb"); err != nil || !report.Ok() {
+		t.Errorf("Rewritten file failed verification: %v %v", err, report)
+	}
+
+	db, err := Open("tmp/rewritten.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.Find([]byte("Kingsbridge")) != nil {
+		t.Errorf("Expected erased space not to be found")
+	}
+
+	if found := fetchSpaceIndex(db, []byte(visits[0].City), "visitor", erased); len(found) != 0 {
+		t.Errorf("Expected erased visitor's events not to be found: %v", found)
+	}
+}
+
+func TestRewriteInPlace(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 1000)
+	writeVisits(t, "tmp/inplace.esdb", visits, WriterOptions{Precision: Milliseconds, BlockSize: 256}, "")
+
+	err := Rewrite("tmp/inplace.esdb", "tmp/inplace.esdb", func(id []byte, e *RewriteEvent) bool {
+		return string(id) != "Kingsbridge"
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open("tmp/inplace.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.Precision() != Milliseconds || db.header.blockSize != 256 {
+		t.Errorf("Expected the file's settings to be kept: %v %d", db.Precision(), db.header.blockSize)
+	}
+
+	if db.Find([]byte("Kingsbridge")) != nil {
+		t.Errorf("Expected erased space not to be found")
+	}
+
+	if visits[0].City != "Kingsbridge" && db.Find([]byte(visits[0].City)) == nil {
+		t.Errorf("Expected space %s to be found", visits[0].City)
+	}
+
+	if err = Rewrite("tmp/missing.esdb", "tmp/missing.esdb", nil); err == nil {
+		t.Errorf("Expected error rewriting a missing file")
+	}
+
+	// Corrupt an event, which is found once the rewritten
+	// file has been partly written.
+	b, _ := ioutil.ReadFile("tmp/inplace.esdb")
+	b[len(b)/4] ^= 0xff
+	ioutil.WriteFile("tmp/corrupt.esdb", b, 0644)
+
+	if err = Rewrite("tmp/corrupt.esdb", "tmp/corrupt.esdb", func(id []byte, e *RewriteEvent) bool { return true }); err == nil {
+		t.Errorf("Expected error rewriting a corrupt file")
+	}
+
+	if found, _ := ioutil.ReadFile("tmp/corrupt.esdb"); !bytes.Equal(found, b) {
+		t.Errorf("Expected corrupt file to be left as it was")
+	}
+
+	if files, _ := ioutil.ReadDir("tmp"); len(files) != 2 {
+		t.Errorf("Expected temporary files to be removed, found %d files", len(files))
+	}
+
+	if _, err = os.Stat("tmp/missing.esdb"); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be written: %v", err)
+	}
+}
+
+func TestRewriteBlockBoundaries(t *testing.T) {
+	writeBoundaryEvents(t, "tmp/boundaries.esdb")
+
+	original, err := Open("tmp/boundaries.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+
+	os.Remove("tmp/boundaries_rewritten.esdb")
+
+	err = Rewrite("tmp/boundaries.esdb", "tmp/boundaries_rewritten.esdb", func(id []byte, e *RewriteEvent) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compareBoundaryEvents(t, "rewritten", "tmp/boundaries_rewritten.esdb", original)
+}
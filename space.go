@@ -2,37 +2,51 @@ package esdb
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"math"
 	"strings"
 
 	"github.com/customerio/esdb/binary"
 	"github.com/customerio/esdb/blocks"
-	"github.com/customerio/esdb/bounded"
 	"github.com/customerio/esdb/sst"
 )
 
 type Scanner func(*Event) bool
 
+// The location of a grouping or index within a space.
+type section struct {
+	offset     int64
+	length     int64
+	summaryLen int64
+
+	// Missing from files written before stats were stored.
+	stats *SectionStats
+}
+
 type Space struct {
 	Id []byte
 
-	reader io.ReadSeeker
-	blocks *blocks.Reader
+	reader io.ReaderAt
+	header fileHeader
+	cache  *blockCache
 	offset int64
 	length int64
 	index  *sst.Reader
 }
 
 // Opens a space for reading given a reader, and an offset/length of
-// the spaces position within the file.
-func openSpace(reader io.ReadSeeker, id []byte, offset, length int64) *Space {
-	if st, err := findSpaceIndex(reader, offset, length); err == nil {
+// the spaces position within the file. Blocks are read through the
+// cache, which may be nil.
+func openSpace(reader io.ReaderAt, header fileHeader, cache *blockCache, id []byte, offset, length int64) *Space {
+	if st, err := findSpaceIndex(reader, offset, length, cache); err == nil {
 
 		return &Space{
 			Id:     id,
 			index:  st,
 			reader: reader,
-			blocks: blocks.NewReader(reader, 4096),
+			header: header,
+			cache:  cache,
 			offset: offset,
 			length: length,
 		}
@@ -59,96 +73,313 @@ func (s *Space) Iterate(process func(g string) bool) error {
 	}
 }
 
-func (s *Space) Scan(grouping string, scanner Scanner) {
-	if offset := s.findGroupingOffset(grouping); offset > 0 {
-		// Move to the beginning of the grouping section in the file.
-		s.blocks.Seek(s.offset+offset, 0)
+// Returns an iterator over the events of a grouping, newest first.
+func (s *Space) Events(grouping string) EventIterator {
+	return s.EventsRange(grouping, math.MinInt64, math.MaxInt64)
+}
 
-		// Event groupings are sequentially stored. So, pull
-		// events out of the muck until we don't find any more.
-		for {
-			event := pullEvent(s.blocks)
+// Returns an iterator over the events of a grouping with timestamps
+// between from and to (inclusive), newest first. Blocks containing
+// only events newer than the range are skipped entirely.
+func (s *Space) EventsRange(grouping string, from, to int) EventIterator {
+	sec, err := s.findSection("g" + grouping)
+	if sec == nil {
+		return emptyIterator(err)
+	}
 
-			if event == nil || !scanner(event) {
-				return
-			}
+	events := s.newReader()
+
+	// Move to the block containing the newest event within
+	// the range, or to the beginning of the grouping section
+	// if it doesn't have a summary.
+	if err = s.seek(events, sec, to); err != nil {
+		return emptyIterator(err)
+	}
+
+	return &eventIterator{
+		space:  s,
+		header: s.header,
+		events: events,
+		from:   from,
+		to:     to,
+	}
+}
+
+// Returns an iterator over the events of an index, newest first.
+func (s *Space) IndexEvents(name, value string) EventIterator {
+	return s.IndexEventsRange(name, value, math.MinInt64, math.MaxInt64)
+}
+
+// Returns an iterator over the events of an index with timestamps
+// between from and to (inclusive), newest first. Blocks of the index
+// containing only entries for events newer than the range are
+// skipped entirely.
+func (s *Space) IndexEventsRange(name, value string, from, to int) EventIterator {
+	sec, err := s.findSection("i" + name + ":" + value)
+	if sec == nil {
+		return emptyIterator(err)
+	}
+
+	// An index is block encoded, so fire up
+	// a block reader, and seek to the start
+	// of the index.
+	index := s.newSectionReader(sec)
+
+	if err = s.seek(index, sec, to); err != nil {
+		return emptyIterator(err)
+	}
+
+	return &eventIterator{
+		space:  s,
+		header: s.header,
+		events: s.newReader(),
+		index:  index,
+		from:   from,
+		to:     to,
+	}
+}
+
+// Returns an iterator over the events matching a query, newest first.
+func (s *Space) QueryEvents(q Query) EventIterator {
+	return s.QueryEventsRange(q, math.MinInt64, math.MaxInt64)
+}
+
+// Returns an iterator over the events matching a query with
+// timestamps between from and to (inclusive), newest first.
+func (s *Space) QueryEventsRange(q Query, from, to int) EventIterator {
+	postings, err := q.postings(s, from, to)
+	if err != nil {
+		return emptyIterator(err)
+	}
+
+	return &queryIterator{
+		space:    s,
+		postings: postings,
+		events:   s.newReader(),
+	}
+}
+
+// Returns an iterator over the events of a grouping, oldest first.
+// Groupings of files written without section summaries are read
+// through once before their events are returned.
+func (s *Space) RevEvents(grouping string) EventIterator {
+	sec, err := s.findSection("g" + grouping)
+	if sec == nil {
+		return emptyIterator(err)
+	}
+
+	return s.newReverseIterator(sec, false)
+}
+
+// Returns an iterator over the events of an index, oldest first.
+// Indexes of files written without section summaries are read
+// through once before their events are returned.
+func (s *Space) RevIndexEvents(name, value string) EventIterator {
+	sec, err := s.findSection("i" + name + ":" + value)
+	if sec == nil {
+		return emptyIterator(err)
+	}
+
+	return s.newReverseIterator(sec, true)
+}
+
+func (s *Space) Scan(grouping string, scanner Scanner) error {
+	return scan(s.Events(grouping), scanner)
+}
+
+// Scans the events of a grouping with timestamps between from and
+// to (inclusive), newest first.
+func (s *Space) ScanRange(grouping string, from, to int, scanner Scanner) error {
+	return scan(s.EventsRange(grouping, from, to), scanner)
+}
+
+func (s *Space) ScanIndex(name, value string, scanner Scanner) error {
+	return scan(s.IndexEvents(name, value), scanner)
+}
+
+// Scans the events of an index with timestamps between from and
+// to (inclusive), newest first.
+func (s *Space) ScanIndexRange(name, value string, from, to int, scanner Scanner) error {
+	return scan(s.IndexEventsRange(name, value, from, to), scanner)
+}
+
+// Scans the events matching a query, newest first.
+func (s *Space) ScanQuery(q Query, scanner Scanner) error {
+	return scan(s.QueryEvents(q), scanner)
+}
+
+// Scans the events matching a query with timestamps
+// between from and to (inclusive), newest first.
+func (s *Space) ScanQueryRange(q Query, from, to int, scanner Scanner) error {
+	return scan(s.QueryEventsRange(q, from, to), scanner)
+}
+
+// Scans the events of a grouping oldest first.
+func (s *Space) RevScan(grouping string, scanner Scanner) error {
+	return scan(s.RevEvents(grouping), scanner)
+}
+
+// Scans the events of an index oldest first.
+func (s *Space) RevScanIndex(name, value string, scanner Scanner) error {
+	return scan(s.RevIndexEvents(name, value), scanner)
+}
+
+// Passes each event from the iterator to the scanner,
+// until the scanner returns false.
+func scan(iter EventIterator, scanner Scanner) error {
+	for iter.Next() {
+		if !scanner(iter.Event()) {
+			break
 		}
 	}
+
+	return iter.Close()
 }
 
-func (s *Space) ScanIndex(name, value string, scanner Scanner) {
-	if reader := s.findIndex(name, value); reader != nil {
-		for {
-			// If the next byte is 0, then that's an empty event offset,
-			// marking the end of the index. Nothing more to see here.
-			if next := reader.Peek(1); len(next) == 0 || next[0] == 0 {
-				return
-			}
+// Each reader gets its own position within the file, so
+// multiple scans of the space can be interleaved, or run
+// concurrently.
+func (s *Space) newReader() *blocks.Reader {
+	return newBlockReader(s.reader, math.MaxInt64, s.header.blockSize, s.cache)
+}
 
-			// Each entry in the index is a 64 bit integer for the
-			// event's block offset in the file, and a 16 bit integer
-			// for the event's offset within the block (as each block
-			// is 4096 bytes long)
-			block := binary.ReadInt64(reader)
-			offset := binary.ReadInt16(reader)
+// Like newReader, but the reader can't read beyond
+// the end of the section.
+func (s *Space) newSectionReader(sec *section) *blocks.Reader {
+	end := s.offset + sec.offset + sec.length
+	return newBlockReader(s.reader, end, s.header.blockSize, s.cache)
+}
 
-			// Move to the event's block
-			s.blocks.Seek(s.offset+block, 0)
+// Moves the reader to the first entry of a section which could have
+// a timestamp at or before the given timestamp.
+func (s *Space) seek(reader *blocks.Reader, sec *section, timestamp int) error {
+	start, skip := sec.offset, int64(0)
 
-			// Read all data prior to the current event's offset.
-			binary.ReadBytes(s.blocks, offset)
+	if timestamp < math.MaxInt64 {
+		sum, err := s.readSummary(sec)
+		if err != nil {
+			return err
+		}
+
+		if entry := sum.seek(timestamp); entry != nil {
+			start, skip = entry.block, int64(entry.offset)
+		}
+	}
 
-			event := pullEvent(s.blocks)
+	return s.seekBlock(reader, start, skip)
+}
 
-			if event == nil || !scanner(event) {
-				return
-			}
+// Moves the reader to the given offset within a block.
+func (s *Space) seekBlock(reader *blocks.Reader, block, offset int64) error {
+	// Move to the block
+	if _, err := reader.Seek(s.offset+block, 0); err != nil {
+		return err
+	}
+
+	// Read all data prior to the offset.
+	_, err := binary.ReadFull(reader, offset)
+	return err
+}
+
+// Reads the timestamp of the event an index entry points at, for
+// files without timestamps in their index entries. Only the event's
+// timestamp is read, and not its data.
+func (s *Space) readEntryTimestamp(events *blocks.Reader, entry *indexEntry) error {
+	if err := s.seekBlock(events, entry.block, entry.offset); err != nil {
+		return err
+	}
+
+	if _, err := binary.ReadUvarintChecked(events); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
+
+		return err
 	}
+
+	timestamp, err := pullTimestamp(events, s.header)
+	entry.timestamp = timestamp
+
+	return err
 }
 
-func (s *Space) findGroupingOffset(name string) int64 {
-	if val, err := s.index.Get([]byte("g" + name)); err == nil {
-		// The entry in the SSTable index for groupings
-		// is variable length integers integers for the
-		// offset and length of the index within
-		// the file. In this case, we just need the
-		// offset to find it's starting point.
-		return binary.ReadUvarint(bytes.NewReader(val))
+// Finds a grouping or index by its key. Returns a nil section
+// without an error if the space doesn't contain it.
+func (s *Space) findSection(key string) (*section, error) {
+	val, err := s.index.Get([]byte(key))
+	if err != nil {
+		if err.Error() == "not found" {
+			err = nil
+		}
+
+		return nil, err
 	}
 
-	return 0
+	return decodeSection(val), nil
 }
 
-// Finds and returns an index by it's name and value.
-func (s *Space) findIndex(name, value string) *blocks.Reader {
-	if val, err := s.index.Get([]byte("i" + name + ":" + value)); err == nil {
-		// The entry in the SSTable index for indexes
-		// is variable length integers integers for the
-		// offset and length of the index within
-		// the file. In this case, we just need the
-		// offset to find it's starting point.
-		offset := binary.ReadUvarint(bytes.NewReader(val))
+// Decodes the entry of a grouping or index in the space's SSTable index.
+func decodeSection(val []byte) *section {
+	// The entry in the SSTable index for groupings and
+	// indexes is variable length integers for the offset
+	// and length of the section, the length of the
+	// summary following it, and the section's stats:
+	//
+	//	[Uvarint:offset][Uvarint:length][Uvarint:summary length]
+	//	[Uvarint:events][Varint:min timestamp][Varint:max timestamp][Uvarint:blocks]
+	//
+	// Summaries and stats are missing from files
+	// written before they were introduced.
+	r := bytes.NewReader(val)
+
+	sec := &section{
+		offset:     binary.ReadUvarint(r),
+		length:     binary.ReadUvarint(r),
+		summaryLen: binary.ReadUvarint(r),
+	}
+
+	if r.Len() > 0 {
+		sec.stats = &SectionStats{
+			Events:       int(binary.ReadUvarint(r)),
+			MinTimestamp: int(binary.ReadVarint(r)),
+			MaxTimestamp: int(binary.ReadVarint(r)),
+			Blocks:       int(binary.ReadUvarint(r)),
+			Bytes:        sec.length,
+		}
+	}
 
-		// An index is block encoded, so fire up
-		// a block reader, and seek to the start
-		// of the index.
-		reader := blocks.NewReader(s.reader, 4096)
-		reader.Seek(s.offset+offset, 0)
+	return sec
+}
 
-		return reader
+// Reads the summary stored after a grouping or index section.
+func (s *Space) readSummary(sec *section) (summary, error) {
+	if sec.summaryLen == 0 {
+		return nil, nil
 	}
 
-	return nil
+	b, err := binary.ReadFull(s.sectionReader(sec.offset+sec.length, sec.summaryLen), sec.summaryLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return readSummary(b), nil
+}
+
+// Returns a reader of length bytes at the given offset within the space.
+func (s *Space) sectionReader(offset, length int64) *io.SectionReader {
+	return io.NewSectionReader(s.reader, s.offset+offset, length)
 }
 
-func findSpaceIndex(r io.ReadSeeker, offset, length int64) (*sst.Reader, error) {
+func findSpaceIndex(r io.ReaderAt, offset, length int64, cache *blockCache) (*sst.Reader, error) {
 	footerOffset := offset + length - 8
 
 	// The last 8 bytes in the file is the length
 	// of the SSTable grouping index.
-	r.Seek(footerOffset, 0)
-	indexLen := binary.ReadInt64(r)
+	indexLen := binary.ReadInt64At(r, footerOffset)
+
+	if indexLen <= 0 || indexLen > length-8 {
+		return nil, errors.New("esdb: invalid space index length")
+	}
 
-	return sst.NewReader(bounded.New(r, footerOffset-indexLen, footerOffset), indexLen)
+	return openTable(r, footerOffset-indexLen, indexLen, cache)
 }
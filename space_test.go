@@ -3,16 +3,18 @@ package esdb
 import (
 	"bytes"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
 func create(id []byte) *Space {
 	buffer := bytes.NewBuffer([]byte{})
-	writer := newSpace(buffer, []byte("a"))
+	writer := newSpace(buffer, currentHeader(), []byte("a"))
 	populateSpace(writer)
 	writer.write()
 
-	return openSpace(bytes.NewReader(buffer.Bytes()), []byte("a"), 0, int64(buffer.Len()))
+	return openSpace(bytes.NewReader(buffer.Bytes()), currentHeader(), nil, []byte("a"), 0, int64(buffer.Len()))
 }
 
 func populateSpace(space *spaceWriter) {
@@ -106,3 +108,93 @@ func TestSpacePrimaryIteration(t *testing.T) {
 		t.Errorf("Incorrect space groupings found. wanted: %v, found: %v", []string{"a", "b"}, found)
 	}
 }
+
+func createLarge(count int) *Space {
+	buffer := bytes.NewBuffer([]byte{})
+	writer := newSpace(buffer, currentHeader(), []byte("a"))
+
+	for i := 0; i < count; i++ {
+		data := []byte(strconv.Itoa(i) + strings.Repeat("-", 100))
+		writer.add(newEvent(data, i), "", map[string]string{"even": strconv.FormatBool(i%2 == 0)})
+	}
+
+	writer.write()
+
+	return openSpace(bytes.NewReader(buffer.Bytes()), currentHeader(), nil, []byte("a"), 0, int64(buffer.Len()))
+}
+
+func TestSpaceRangeScanning(t *testing.T) {
+	space := createLarge(1000)
+
+	var tests = []struct {
+		from int
+		to   int
+		want []int
+	}{
+		{500, 503, []int{503, 502, 501, 500}},
+		{0, 2, []int{2, 1, 0}},
+		{998, 2000, []int{999, 998}},
+		{-10, 0, []int{0}},
+		{1000, 2000, []int{}},
+		{3, 2, []int{}},
+	}
+
+	for i, test := range tests {
+		found := make([]int, 0)
+
+		space.ScanRange("", test.from, test.to, func(event *Event) bool {
+			found = append(found, event.Timestamp)
+			return true
+		})
+
+		if !reflect.DeepEqual(test.want, found) {
+			t.Errorf("Case #%v: wanted: %v, found: %v", i, test.want, found)
+		}
+	}
+}
+
+func TestSpaceIndexRangeScanning(t *testing.T) {
+	space := createLarge(1000)
+
+	var tests = []struct {
+		value string
+		from  int
+		to    int
+		want  []int
+	}{
+		{"true", 500, 505, []int{504, 502, 500}},
+		{"false", 500, 505, []int{505, 503, 501}},
+		{"true", 0, 3, []int{2, 0}},
+		{"false", 990, 2000, []int{999, 997, 995, 993, 991}},
+		{"false", 0, 0, []int{}},
+	}
+
+	for i, test := range tests {
+		found := make([]int, 0)
+
+		space.ScanIndexRange("even", test.value, test.from, test.to, func(event *Event) bool {
+			found = append(found, event.Timestamp)
+			return true
+		})
+
+		if !reflect.DeepEqual(test.want, found) {
+			t.Errorf("Case #%v: wanted: %v, found: %v", i, test.want, found)
+		}
+	}
+}
+
+func TestSpaceRangeSkipsBlocks(t *testing.T) {
+	space := createLarge(1000)
+
+	sec, _ := space.findSection("g")
+	sum, _ := space.readSummary(sec)
+	entry := sum.seek(10)
+
+	if entry == nil || entry.block <= sec.offset || entry.timestamp <= 10 {
+		t.Fatalf("Expected to skip to a later block, found: %v", entry)
+	}
+
+	if next := sum.seek(entry.timestamp - 1); next.block != entry.block {
+		t.Errorf("Wrong summary block for timestamp %d: wanted: %d, found: %d", entry.timestamp-1, entry.block, next.block)
+	}
+}
@@ -8,13 +8,13 @@ import (
 	"strings"
 
 	"github.com/customerio/esdb/binary"
-	"github.com/customerio/esdb/sst"
 )
 
 type spaceWriter struct {
 	Id []byte
 
 	writer io.Writer
+	header fileHeader
 
 	written bool
 
@@ -23,15 +23,19 @@ type spaceWriter struct {
 }
 
 type index struct {
-	offset int64
-	length int64
-	evs    events
+	offset     int64
+	length     int64
+	summaryLen int64
+	evs        events
+	summary    summary
+	stats      SectionStats
 }
 
-func newSpace(writer io.Writer, id []byte) *spaceWriter {
+func newSpace(writer io.Writer, header fileHeader, id []byte) *spaceWriter {
 	return &spaceWriter{
 		Id:         id,
 		writer:     writer,
+		header:     header,
 		indexes:    make(map[string]*index),
 		indexNames: make(sort.StringSlice, 0),
 	}
@@ -112,13 +116,19 @@ func (w *spaceWriter) writeBlocks(written int64, out io.Writer) (int64, error) {
 		buf := new(bytes.Buffer)
 
 		if strings.HasPrefix(name, "g") {
-			writeEventBlocks(w.indexes[name], buf)
+			writeEventBlocks(w.indexes[name], buf, w.header)
 		} else {
-			writeIndexBlocks(w.indexes[name], buf)
+			writeIndexBlocks(w.indexes[name], buf, w.header)
 		}
 
 		w.indexes[name].evs = nil
 
+		// The section's summary is stored directly
+		// after the section's blocks.
+		summaryLen, _ := w.indexes[name].summary.write(buf)
+		w.indexes[name].summaryLen = summaryLen
+		w.indexes[name].summary = nil
+
 		n, err := buf.WriteTo(out)
 		off += n
 		if err != nil {
@@ -133,18 +143,26 @@ func (w *spaceWriter) writeBlocks(written int64, out io.Writer) (int64, error) {
 // names to their offsets in the file.
 func (w *spaceWriter) writeIndex(written int64, out io.Writer) (length int64, err error) {
 	buf := new(bytes.Buffer)
-	st := sst.NewWriter(buf)
+	st := newIndexWriter(buf)
 
 	sort.Stable(w.indexNames)
 
 	// For each grouping or index, we index the section's
-	// byte offset in the file and the length in bytes
-	// of all data in the grouping/index.
+	// byte offset in the file, the length in bytes
+	// of all data in the grouping/index, the length
+	// of the section's summary which follows it, and
+	// the section's stats.
 	for _, name := range w.indexNames {
 		buf := new(bytes.Buffer)
+		stats := w.indexes[name].stats
 
 		binary.WriteUvarint64(buf, w.indexes[name].offset)
 		binary.WriteUvarint64(buf, w.indexes[name].length)
+		binary.WriteUvarint64(buf, w.indexes[name].summaryLen)
+		binary.WriteUvarint(buf, stats.Events)
+		binary.WriteVarint(buf, int64(stats.MinTimestamp))
+		binary.WriteVarint(buf, int64(stats.MaxTimestamp))
+		binary.WriteUvarint(buf, stats.Blocks)
 
 		if err = st.Set([]byte(name), buf.Bytes()); err != nil {
 			return
@@ -163,3 +181,123 @@ func (w *spaceWriter) writeFooter(written int64, out io.Writer, indexLen int64)
 	binary.WriteInt64(buf, indexLen)
 	return buf.WriteTo(out)
 }
+
+// Writes a space from its events, sorted by section and then newest
+// first, as returned by a recordSorter. Produces exactly the same bytes
+// as a spaceWriter given the same events. The entries of the space's
+// indexes are sorted using the entries recordSorter, so only a limited
+// number of them are held in memory.
+func writeSortedSpace(out io.Writer, header fileHeader, events *mergeIterator, entries *recordSorter) (int64, error) {
+	w := newSpace(out, header, nil)
+	counter := &countingWriter{writer: out}
+
+	if _, err := w.writeHeader(0, counter); err != nil {
+		return counter.written, err
+	}
+
+	err := w.writeSections(counter, events, func(i *index) (func(*record) error, func()) {
+		writer := newEventBlockWriter(i, counter, header)
+
+		return func(rec *record) error {
+			event := &Event{Data: rec.data, Timestamp: rec.timestamp}
+			writer.add(event)
+
+			// Now the event's location is known, it
+			// can be added to each of its indexes.
+			for _, key := range rec.indexes {
+				entry := &record{section: key, timestamp: rec.timestamp, seq: rec.seq, block: event.block, offset: event.offset}
+
+				if err := entries.add("", entry); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}, writer.close
+	})
+
+	if err != nil {
+		return counter.written, err
+	}
+
+	err = w.writeSections(counter, entries.take(""), func(i *index) (func(*record) error, func()) {
+		writer := newIndexBlockWriter(i, counter, header)
+
+		return func(rec *record) error {
+			writer.add(&Event{Timestamp: rec.timestamp, block: rec.block, offset: rec.offset})
+			return nil
+		}, writer.close
+	})
+
+	if err != nil {
+		return counter.written, err
+	}
+
+	indexN, err := w.writeIndex(counter.written, counter)
+	if err != nil {
+		return counter.written, err
+	}
+
+	_, err = w.writeFooter(counter.written, counter, indexN)
+
+	return counter.written, err
+}
+
+// Writes sections from sorted records. Whenever the section changes,
+// the previous section is finished and its summary written, and a new
+// section is started, returning functions to add records to it, and
+// to finish it.
+func (w *spaceWriter) writeSections(out *countingWriter, records *mergeIterator, start func(*index) (func(*record) error, func())) error {
+	var (
+		current *index
+		add     func(*record) error
+		finish  func()
+	)
+
+	end := func() error {
+		finish()
+
+		// The section's summary is stored directly
+		// after the section's blocks.
+		summaryLen, err := current.summary.write(out)
+		current.summaryLen = summaryLen
+		current.summary = nil
+
+		return err
+	}
+
+	for {
+		rec, err := records.next()
+		if err != nil {
+			return err
+		}
+
+		if rec == nil {
+			break
+		}
+
+		if current == nil || rec.section != w.indexNames[len(w.indexNames)-1] {
+			if current != nil {
+				if err = end(); err != nil {
+					return err
+				}
+			}
+
+			current = &index{offset: out.written}
+			w.indexes[rec.section] = current
+			w.indexNames = append(w.indexNames, rec.section)
+
+			add, finish = start(current)
+		}
+
+		if err = add(rec); err != nil {
+			return err
+		}
+	}
+
+	if current != nil {
+		return end()
+	}
+
+	return nil
+}
@@ -10,7 +10,7 @@ import (
 )
 
 func TestWriteSpaceImmutability(t *testing.T) {
-	writer := newSpace(new(bytes.Buffer), []byte("a"))
+	writer := newSpace(new(bytes.Buffer), currentHeader(), []byte("a"))
 	writer.write()
 
 	err := writer.add(newEvent([]byte("1"), 1), "b", nil)
@@ -23,7 +23,7 @@ func TestWriteSpaceImmutability(t *testing.T) {
 func TestWriteSpaceGrouping(t *testing.T) {
 	w := new(bytes.Buffer)
 
-	writer := newSpace(w, []byte("a"))
+	writer := newSpace(w, currentHeader(), []byte("a"))
 
 	e1data := []byte("abc")
 	e2data := []byte("b")
@@ -41,7 +41,7 @@ func TestWriteSpaceGrouping(t *testing.T) {
 
 	writer.write()
 
-	sst, _ := findSpaceIndex(bytes.NewReader(w.Bytes()), 0, int64(w.Len()))
+	sst, _ := findSpaceIndex(bytes.NewReader(w.Bytes()), 0, int64(w.Len()), nil)
 
 	var tests = []struct {
 		key        string
@@ -50,8 +50,8 @@ func TestWriteSpaceGrouping(t *testing.T) {
 		data       [][]byte
 		timestamps []int
 	}{
-		{"g1", 1, 20, [][]byte{e4data, e1data}, []int{4, 1}},
-		{"g2", 21, 16, [][]byte{e2data, e3data}, []int{3, 2}},
+		{"g1", 1, 18, [][]byte{e4data, e1data}, []int{4, 1}},
+		{"g2", 24, 14, [][]byte{e2data, e3data}, []int{3, 2}},
 	}
 
 	for i, test := range tests {
@@ -69,7 +69,7 @@ func TestWriteSpaceGrouping(t *testing.T) {
 		reader.Seek(int64(offset), 0)
 
 		for j, data := range test.data {
-			found := pullEvent(reader)
+			found, _ := pullEvent(reader, currentHeader())
 
 			if string(found.Data) != string(data) {
 				t.Errorf("Case %d/%d: Wrong event data found: want: %s found: %s", i, j, data, found.Data)
@@ -79,14 +79,14 @@ func TestWriteSpaceGrouping(t *testing.T) {
 		reader.Seek(int64(offset), 0)
 
 		for j, ts := range test.timestamps {
-			found := pullEvent(reader)
+			found, _ := pullEvent(reader, currentHeader())
 
 			if found.Timestamp != ts {
 				t.Errorf("Case %d/%d: Wrong event timestamp found: want: %d found: %d", i, j, ts, found.Timestamp)
 			}
 		}
 
-		if e := pullEvent(reader); e != nil {
+		if e, _ := pullEvent(reader, currentHeader()); e != nil {
 			t.Errorf("Wrong event found: want: nil found: %s", e.Data)
 		}
 	}
@@ -95,7 +95,7 @@ func TestWriteSpaceGrouping(t *testing.T) {
 func TestWriteSpaceIndexes(t *testing.T) {
 	w := new(bytes.Buffer)
 
-	writer := newSpace(w, []byte("a"))
+	writer := newSpace(w, currentHeader(), []byte("a"))
 
 	e1data := []byte("abc")
 	e2data := []byte("b")
@@ -120,12 +120,12 @@ func TestWriteSpaceIndexes(t *testing.T) {
 		evs     [][]byte
 		indexed events
 	}{
-		{"g1", 1, 32, [][]byte{e4data, e2data, e3data, e1data}, nil},
-		{"ia:1", 33, 21, nil, events{e4, e1}},
-		{"ia:2", 54, 23, nil, events{e2, e3}},
+		{"g1", 1, 24, [][]byte{e4data, e2data, e3data, e1data}, nil},
+		{"ia:1", 30, 31, nil, events{e4, e1}},
+		{"ia:2", 66, 33, nil, events{e2, e3}},
 	}
 
-	sst, _ := findSpaceIndex(bytes.NewReader(w.Bytes()), 0, int64(w.Len()))
+	sst, _ := findSpaceIndex(bytes.NewReader(w.Bytes()), 0, int64(w.Len()), nil)
 
 	for i, test := range tests {
 		val, _ := sst.Get([]byte(test.key))
@@ -144,12 +144,12 @@ func TestWriteSpaceIndexes(t *testing.T) {
 
 		if len(test.evs) > 0 {
 			for j, data := range test.evs {
-				if e := pullEvent(reader); !reflect.DeepEqual(e.Data, data) {
+				if e, _ := pullEvent(reader, currentHeader()); !reflect.DeepEqual(e.Data, data) {
 					t.Errorf("Case %d/%d: Wrong event found: want: %s found: %s", i, j, data, e.Data)
 				}
 			}
 
-			if e := pullEvent(reader); e != nil {
+			if e, _ := pullEvent(reader, currentHeader()); e != nil {
 				t.Errorf("Wrong event found: want: nil found: %s", e.Data)
 			}
 		}
@@ -157,6 +157,7 @@ func TestWriteSpaceIndexes(t *testing.T) {
 		for j, event := range test.indexed {
 			block := binary.ReadInt64(reader)
 			offset := binary.ReadInt16(reader)
+			binary.ReadVarint(reader)
 
 			if block != event.block || int(offset) != event.offset {
 				t.Errorf("Case %d/%d: Wrong event index: want: %d,%d found: %d,%d", i, j, event.block, event.offset, block, offset)
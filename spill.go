@@ -0,0 +1,533 @@
+package esdb
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/customerio/esdb/binary"
+)
+
+// A record is either an event added to a space, or an entry of
+// one of the space's indexes, as held by a recordSorter.
+type record struct {
+	// The key of the grouping or index section the record is in.
+	section   string
+	timestamp int
+
+	// The order in which records were added, so records with the
+	// same timestamp are written in the same order as the in-memory
+	// path's stable sort would write them.
+	seq int64
+
+	// For events, the event's data and the keys
+	// of the index sections the event is in.
+	data    []byte
+	indexes []string
+
+	// For index entries, the location of the event.
+	block  int64
+	offset int
+}
+
+// Records within a section are ordered newest first.
+func (r *record) less(other *record) bool {
+	if r.section != other.section {
+		return r.section < other.section
+	}
+
+	if r.timestamp != other.timestamp {
+		return r.timestamp > other.timestamp
+	}
+
+	if r.seq != other.seq {
+		return r.seq < other.seq
+	}
+
+	// Index entries read back from a file, which are
+	// sorted by the location of the event they're for.
+	if r.block != other.block {
+		return r.block < other.block
+	}
+
+	return r.offset < other.offset
+}
+
+// An estimate of the memory used by the record.
+func (r *record) size() int64 {
+	size := 96 + len(r.section) + len(r.data)
+
+	for _, index := range r.indexes {
+		size += 16 + len(index)
+	}
+
+	return int64(size)
+}
+
+// Records are encoded in the following byte format:
+// [Uvarint:length][bytes(length):section][Varint:timestamp][Uvarint:seq]
+// [Uvarint:length][bytes(length):data][Uvarint:indexes]([Uvarint:length][bytes(length):index])...
+// [Uvarint:block][Uvarint:offset]
+func (r *record) write(out io.Writer) {
+	binary.WriteUvarint(out, len(r.section))
+	io.WriteString(out, r.section)
+	binary.WriteVarint(out, int64(r.timestamp))
+	binary.WriteUvarint64(out, r.seq)
+	binary.WriteUvarint(out, len(r.data))
+	out.Write(r.data)
+	binary.WriteUvarint(out, len(r.indexes))
+
+	for _, index := range r.indexes {
+		binary.WriteUvarint(out, len(index))
+		io.WriteString(out, index)
+	}
+
+	binary.WriteUvarint64(out, r.block)
+	binary.WriteUvarint(out, r.offset)
+}
+
+func readRecord(r *bufio.Reader) (*record, error) {
+	section, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &record{section: section}
+
+	timestamp, err := binary.ReadVarintChecked(r)
+	if err != nil {
+		return nil, err
+	}
+	rec.timestamp = int(timestamp)
+
+	if rec.seq, err = binary.ReadUvarintChecked(r); err != nil {
+		return nil, err
+	}
+
+	length, err := binary.ReadUvarintChecked(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.data, err = binary.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+
+	count, err := binary.ReadUvarintChecked(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := int64(0); i < count; i++ {
+		index, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		rec.indexes = append(rec.indexes, index)
+	}
+
+	if rec.block, err = binary.ReadUvarintChecked(r); err != nil {
+		return nil, err
+	}
+
+	offset, err := binary.ReadUvarintChecked(r)
+	rec.offset = int(offset)
+
+	return rec, err
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	length, err := binary.ReadUvarintChecked(r)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := binary.ReadFull(r, length)
+	return string(b), err
+}
+
+// The number of runs of the same level which are merged into a
+// single run of the next level. This bounds the number of open
+// files, and the number of runs merged when reading a space.
+const mergeRuns = 16
+
+// A run is a temporary file of sorted records. Each space's
+// records are stored together in a segment of the file.
+//
+// Runs spilled from memory are level 0, and runs merged from
+// runs of a level are one level higher.
+type run struct {
+	file     *os.File
+	level    int
+	segments map[string]segment
+}
+
+type segment struct {
+	offset int64
+	length int64
+}
+
+// Sorts the records of many spaces, holding a limited amount of
+// records in memory. Once the limit is reached, the records in
+// memory are sorted and spilled to a run on disk. The runs are
+// merged when each space's records are read back.
+type recordSorter struct {
+	limit   int64
+	dir     string
+	size    int64
+	pending map[string][]*record
+	runs    []*run
+}
+
+func newRecordSorter(limit int64, dir string) *recordSorter {
+	return &recordSorter{
+		limit:   limit,
+		dir:     dir,
+		pending: make(map[string][]*record),
+	}
+}
+
+func (s *recordSorter) add(space string, r *record) error {
+	s.pending[space] = append(s.pending[space], r)
+	s.size += r.size()
+
+	if s.size >= s.limit {
+		return s.spill()
+	}
+
+	return nil
+}
+
+// Returns true if any records for the space have been added.
+func (s *recordSorter) has(space string) bool {
+	if _, ok := s.pending[space]; ok {
+		return true
+	}
+
+	for _, run := range s.runs {
+		if _, ok := run.segments[space]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns all spaces with records, sorted by id.
+func (s *recordSorter) spaces() []string {
+	seen := make(map[string][]*record)
+
+	for space := range s.pending {
+		seen[space] = nil
+	}
+
+	for _, space := range runSpaces(s.runs) {
+		seen[space] = nil
+	}
+
+	return sortedKeys(seen)
+}
+
+// Sorts the records in memory and writes them to a new run.
+func (s *recordSorter) spill() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	spaces := sortedKeys(s.pending)
+
+	r, err := s.writeRun(0, spaces, func(space string) *mergeIterator {
+		records := s.pending[space]
+		sortRecords(records)
+
+		iter := &mergeIterator{}
+		iter.push(&sliceCursor{records: records})
+
+		return iter
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, r)
+	s.pending = make(map[string][]*record)
+	s.size = 0
+
+	return s.compact()
+}
+
+// Merges runs of the same level once there are enough of them.
+func (s *recordSorter) compact() error {
+	for level := 0; ; level++ {
+		runs := make([]*run, 0, mergeRuns)
+		rest := make([]*run, 0, len(s.runs))
+
+		for _, r := range s.runs {
+			if r.level == level {
+				runs = append(runs, r)
+			} else {
+				rest = append(rest, r)
+			}
+		}
+
+		if len(runs) < mergeRuns {
+			return nil
+		}
+
+		merged, err := s.writeRun(level+1, runSpaces(runs), func(space string) *mergeIterator {
+			iter := &mergeIterator{}
+			pushRuns(iter, runs, space)
+			return iter
+		})
+
+		if err != nil {
+			return err
+		}
+
+		s.runs = append(rest, merged)
+
+		if err = removeRuns(runs); err != nil {
+			return err
+		}
+	}
+}
+
+// Writes the records of each space, in order of the spaces, to a new run.
+func (s *recordSorter) writeRun(level int, spaces []string, records func(space string) *mergeIterator) (*run, error) {
+	file, err := ioutil.TempFile(s.dir, "esdb-spill-")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &run{file: file, level: level, segments: make(map[string]segment)}
+
+	out := bufio.NewWriter(file)
+	var offset int64
+
+	for _, space := range spaces {
+		counter := &countingWriter{writer: out}
+		iter := records(space)
+
+		for {
+			rec, err := iter.next()
+			if err != nil {
+				removeRuns([]*run{r})
+				return nil, err
+			}
+
+			if rec == nil {
+				break
+			}
+
+			rec.write(counter)
+		}
+
+		r.segments[space] = segment{offset, counter.written}
+		offset += counter.written
+	}
+
+	if err = out.Flush(); err != nil {
+		removeRuns([]*run{r})
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Returns an iterator over the space's records in sorted order,
+// and forgets them.
+func (s *recordSorter) take(space string) *mergeIterator {
+	iter := &mergeIterator{}
+
+	if records := s.pending[space]; len(records) > 0 {
+		sortRecords(records)
+		iter.push(&sliceCursor{records: records})
+
+		for _, rec := range records {
+			s.size -= rec.size()
+		}
+	}
+
+	delete(s.pending, space)
+
+	pushRuns(iter, s.runs, space)
+
+	return iter
+}
+
+// Adds cursors over the space's segment of each run to the
+// iterator, and forgets the segments.
+func pushRuns(iter *mergeIterator, runs []*run, space string) {
+	for _, run := range runs {
+		if seg, ok := run.segments[space]; ok {
+			reader := io.NewSectionReader(run.file, seg.offset, seg.length)
+			iter.push(&runCursor{reader: bufio.NewReader(reader)})
+			delete(run.segments, space)
+		}
+	}
+}
+
+// Returns all spaces with records in the runs, sorted by id.
+func runSpaces(runs []*run) []string {
+	seen := make(map[string][]*record)
+
+	for _, run := range runs {
+		for space := range run.segments {
+			seen[space] = nil
+		}
+	}
+
+	return sortedKeys(seen)
+}
+
+func removeRuns(runs []*run) error {
+	var err error
+
+	for _, run := range runs {
+		run.file.Close()
+
+		if e := os.Remove(run.file.Name()); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Removes all of the sorter's runs from disk.
+func (s *recordSorter) close() error {
+	err := removeRuns(s.runs)
+
+	s.runs = nil
+	s.pending = make(map[string][]*record)
+	s.size = 0
+
+	return err
+}
+
+func sortRecords(records []*record) {
+	sort.Slice(records, func(i, j int) bool { return records[i].less(records[j]) })
+}
+
+func sortedKeys(m map[string][]*record) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// A cursor steps through a sorted sequence of records.
+type cursor interface {
+	next() (*record, error)
+}
+
+type sliceCursor struct {
+	records []*record
+}
+
+func (c *sliceCursor) next() (*record, error) {
+	if len(c.records) == 0 {
+		return nil, nil
+	}
+
+	rec := c.records[0]
+	c.records = c.records[1:]
+
+	return rec, nil
+}
+
+type runCursor struct {
+	reader *bufio.Reader
+}
+
+func (c *runCursor) next() (*record, error) {
+	if _, err := c.reader.Peek(1); err == io.EOF {
+		return nil, nil
+	}
+
+	rec, err := readRecord(c.reader)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return rec, err
+}
+
+// Merges several sorted cursors into a single sorted sequence.
+type mergeIterator struct {
+	heads []*mergeHead
+	err   error
+}
+
+type mergeHead struct {
+	record *record
+	cursor cursor
+}
+
+func (m *mergeIterator) push(c cursor) {
+	rec, err := c.next()
+	if err != nil {
+		m.err = err
+	} else if rec != nil {
+		heap.Push(m, &mergeHead{rec, c})
+	}
+}
+
+// Returns the next record in sorted order, or
+// nil once all cursors have been exhausted.
+func (m *mergeIterator) next() (*record, error) {
+	if m.err != nil || len(m.heads) == 0 {
+		return nil, m.err
+	}
+
+	head := m.heads[0]
+	rec := head.record
+
+	if head.record, m.err = head.cursor.next(); m.err != nil {
+		return nil, m.err
+	}
+
+	if head.record == nil {
+		heap.Pop(m)
+	} else {
+		heap.Fix(m, 0)
+	}
+
+	return rec, nil
+}
+
+// Implements heap.Interface.
+func (m *mergeIterator) Len() int           { return len(m.heads) }
+func (m *mergeIterator) Less(i, j int) bool { return m.heads[i].record.less(m.heads[j].record) }
+func (m *mergeIterator) Swap(i, j int)      { m.heads[i], m.heads[j] = m.heads[j], m.heads[i] }
+
+func (m *mergeIterator) Push(x interface{}) {
+	m.heads = append(m.heads, x.(*mergeHead))
+}
+
+func (m *mergeIterator) Pop() interface{} {
+	n := len(m.heads)
+	head := m.heads[n-1]
+	m.heads = m.heads[:n-1]
+	return head
+}
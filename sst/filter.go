@@ -0,0 +1,246 @@
+package sst
+
+import (
+	"encoding/binary"
+)
+
+// Filters are built for the keys of the data blocks starting
+// within each 2KiB of the table, as with LevelDB.
+const filterBaseLg = 11
+
+// The prefix of the metaindex key of a filter block, which
+// is followed by the name of the filter's policy.
+const filterMetaPrefix = "filter."
+
+// A FilterPolicy builds small filters of the keys of each data
+// block, which are stored in the table's filter block, so Get can
+// rule out keys which aren't in the table without reading the
+// data block they'd be in.
+type FilterPolicy interface {
+	// Identifies the policy's filters within the table.
+	Name() string
+
+	// Appends a filter of the keys to dst.
+	AppendFilter(dst []byte, keys [][]byte) []byte
+
+	// Returns false if the key certainly wasn't one
+	// of the keys of the filter, and true otherwise.
+	MayContain(filter, key []byte) bool
+}
+
+// Returns a FilterPolicy of bloom filters with the given number of
+// bits per key. 10 bits per key rule out roughly 99% of keys which
+// aren't in a table.
+//
+// The filters are laid out like LevelDB's built in bloom filters,
+// but keys are hashed differently, as LevelDB's hash matches far too
+// many keys which only differ in their last few bytes, such as ids.
+func BloomFilter(bitsPerKey int) FilterPolicy {
+	return bloomFilter(bitsPerKey)
+}
+
+// The policies which tables written with filters are read with.
+var filterPolicies = []FilterPolicy{BloomFilter(10)}
+
+type bloomFilter int
+
+func (f bloomFilter) Name() string {
+	return "esdb.BloomFilter"
+}
+
+func (f bloomFilter) AppendFilter(dst []byte, keys [][]byte) []byte {
+	// Probing each key k times, with k = ln(2) * bits per key,
+	// minimizes the chance of false positives.
+	k := uint8(float64(f) * 0.69)
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+
+	bits := len(keys) * int(f)
+	if bits < 64 {
+		bits = 64
+	}
+
+	n := (bits + 7) / 8
+	bits = n * 8
+
+	start := len(dst)
+	dst = append(dst, make([]byte, n)...)
+	filter := dst[start:]
+
+	for _, key := range keys {
+		h, delta := bloomHash(key)
+
+		for j := uint8(0); j < k; j++ {
+			pos := h % uint32(bits)
+			filter[pos/8] |= 1 << (pos % 8)
+			h += delta
+		}
+	}
+
+	return append(dst, k)
+}
+
+func (f bloomFilter) MayContain(filter, key []byte) bool {
+	if len(filter) < 2 {
+		return false
+	}
+
+	bits := uint32(len(filter)-1) * 8
+
+	// Reserved for encodings of other bloom filters.
+	k := filter[len(filter)-1]
+	if k > 30 {
+		return true
+	}
+
+	h, delta := bloomHash(key)
+
+	for j := uint8(0); j < k; j++ {
+		pos := h % bits
+		if filter[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+
+	return true
+}
+
+// Hashes a key into the first bit probed for it, and the distance
+// between each of the bits probed, using 64 bit FNV-1a, mixed with
+// murmur3's finalizer, so every bit of the key affects every bit
+// of the hash.
+func bloomHash(b []byte) (uint32, uint32) {
+	h := uint64(14695981039346656037)
+
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+
+	return uint32(h), uint32(h >> 32)
+}
+
+// Builds the filter block of a table, which is laid out as:
+//
+//	[filter 0]...[filter n-1][uint32: offset of filter 0]...[uint32: offset of filter n-1]
+//	[uint32: offset of the filter offsets][uint8: base lg]
+//
+// Where filter i is of the keys of the data blocks which begin
+// between offsets i << base lg and (i + 1) << base lg of the table.
+type filterWriter struct {
+	policy  FilterPolicy
+	keys    []byte
+	lengths []int
+	data    []byte
+	offsets []uint32
+}
+
+func (w *filterWriter) addKey(key []byte) {
+	w.keys = append(w.keys, key...)
+	w.lengths = append(w.lengths, len(key))
+}
+
+// Called with the offset of each data block as it begins.
+func (w *filterWriter) startBlock(offset uint64) {
+	for index := offset >> filterBaseLg; index > uint64(len(w.offsets)); {
+		w.generate()
+	}
+}
+
+func (w *filterWriter) generate() {
+	w.offsets = append(w.offsets, uint32(len(w.data)))
+
+	if len(w.lengths) == 0 {
+		return
+	}
+
+	keys := make([][]byte, len(w.lengths))
+	start := 0
+
+	for i, length := range w.lengths {
+		keys[i] = w.keys[start : start+length]
+		start += length
+	}
+
+	w.data = w.policy.AppendFilter(w.data, keys)
+	w.keys = w.keys[:0]
+	w.lengths = w.lengths[:0]
+}
+
+func (w *filterWriter) finish() []byte {
+	if len(w.lengths) > 0 {
+		w.generate()
+	}
+
+	tmp := make([]byte, 4)
+	start := uint32(len(w.data))
+
+	for _, offset := range append(w.offsets, start) {
+		binary.LittleEndian.PutUint32(tmp, offset)
+		w.data = append(w.data, tmp...)
+	}
+
+	return append(w.data, filterBaseLg)
+}
+
+// Reads the filter block of a table.
+type filterReader struct {
+	policy  FilterPolicy
+	data    []byte
+	offsets []byte
+	baseLg  uint
+}
+
+func newFilterReader(policy FilterPolicy, data []byte) *filterReader {
+	if len(data) < 5 {
+		return nil
+	}
+
+	start := binary.LittleEndian.Uint32(data[len(data)-5:])
+	if uint64(start) > uint64(len(data)-5) {
+		return nil
+	}
+
+	return &filterReader{
+		policy:  policy,
+		data:    data,
+		offsets: data[start : len(data)-1],
+		baseLg:  uint(data[len(data)-1]),
+	}
+}
+
+// Returns false if the key certainly isn't in
+// the data block beginning at the offset.
+func (r *filterReader) mayContain(offset int64, key []byte) bool {
+	index := uint64(offset) >> r.baseLg
+
+	// The last offset is of the offsets themselves, marking
+	// the end of the last filter.
+	if index >= uint64(len(r.offsets)/4-1) {
+		return true
+	}
+
+	start := binary.LittleEndian.Uint32(r.offsets[4*index:])
+	limit := binary.LittleEndian.Uint32(r.offsets[4*index+4:])
+
+	if start > limit || uint64(limit) > uint64(len(r.data)) {
+		return true
+	}
+
+	// Empty filters are of blocks without any keys.
+	if start == limit {
+		return false
+	}
+
+	return r.policy.MayContain(r.data[start:limit], key)
+}
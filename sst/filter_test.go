@@ -0,0 +1,123 @@
+package sst
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/bits"
+	"testing"
+)
+
+func TestBloomHash(t *testing.T) {
+	// Keys which only differ in their last byte, such as
+	// sequential ids, should be hashed far apart.
+	for i := 0; i < 100; i++ {
+		h1, d1 := bloomHash([]byte(fmt.Sprintf("key%05d", 2*i)))
+		h2, d2 := bloomHash([]byte(fmt.Sprintf("key%05d", 2*i+1)))
+
+		if bits.OnesCount32(h1^h2) < 6 || bits.OnesCount32(d1^d2) < 6 {
+			t.Errorf("Key %d: similar hashes: %x %x, %x %x", i, h1, h2, d1, d2)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	policy := BloomFilter(10)
+
+	for _, n := range []int{1, 10, 100, 1000, 10000} {
+		keys := make([][]byte, n)
+		for i := range keys {
+			keys[i] = []byte(fmt.Sprintf("key%d", i))
+		}
+
+		filter := policy.AppendFilter(nil, keys)
+
+		if max := (n*10+7)/8 + 1; len(filter) > max+8 {
+			t.Errorf("%d keys: filter too large: %d bytes", n, len(filter))
+		}
+
+		for _, key := range keys {
+			if !policy.MayContain(filter, key) {
+				t.Errorf("%d keys: key %q missing from filter", n, key)
+			}
+		}
+
+		matched := 0
+		for i := 0; i < 10000; i++ {
+			if policy.MayContain(filter, []byte(fmt.Sprintf("key%d", n+i))) {
+				matched += 1
+			}
+		}
+
+		if rate := float64(matched) / 10000; rate > 0.02 {
+			t.Errorf("%d keys: false positive rate too high: %.4f", n, rate)
+		}
+	}
+
+	if policy.MayContain(nil, []byte("key")) {
+		t.Errorf("Expected empty filter not to contain keys")
+	}
+}
+
+// Counts the reads made of the underlying reader.
+type countingReader struct {
+	reader io.ReaderAt
+	reads  int
+}
+
+func (r *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	r.reads += 1
+	return r.reader.ReadAt(p, off)
+}
+
+func TestFilteredGet(t *testing.T) {
+	for _, filtered := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+
+		options := WriterOptions{}
+		if filtered {
+			options.Filter = BloomFilter(10)
+		}
+
+		w := NewWriterWithOptions(buf, options)
+
+		for i := 0; i < 10000; i++ {
+			w.Set([]byte(fmt.Sprintf("key%05d", 2*i)), []byte(fmt.Sprintf("value%d", i)))
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		counter := &countingReader{reader: bytes.NewReader(buf.Bytes())}
+
+		r, err := NewReader(counter, int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found := r.filter != nil; found != filtered {
+			t.Errorf("Filtered %v: wrong filter found: %v", filtered, found)
+		}
+
+		for i := 0; i < 10000; i += 7 {
+			if value, err := r.Get([]byte(fmt.Sprintf("key%05d", 2*i))); string(value) != fmt.Sprintf("value%d", i) || err != nil {
+				t.Errorf("Filtered %v: wrong value for key %d: %s,%v", filtered, 2*i, value, err)
+			}
+		}
+
+		opened := counter.reads
+
+		for i := 0; i < 10000; i++ {
+			if _, err := r.Get([]byte(fmt.Sprintf("key%05d", 2*i+1))); err == nil {
+				t.Errorf("Filtered %v: found absent key %d", filtered, 2*i+1)
+			}
+		}
+
+		// The filter rules out most absent keys without reading
+		// the data block they'd be in.
+		if reads := counter.reads - opened; filtered && reads > 200 || !filtered && reads < 10000 {
+			t.Errorf("Filtered %v: wrong number of reads of absent keys: %d", filtered, reads)
+		}
+	}
+}
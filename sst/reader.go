@@ -6,24 +6,69 @@ import (
 	"errors"
 	"io"
 	"sort"
+
+	"github.com/customerio/esdb/blocks"
 )
 
+var ErrChecksumMismatch = errors.New("sst: block checksum mismatch")
+
+var errNotFound = errors.New("not found")
+
 type blockHandle struct {
 	offset int64
 	length int64
 }
 
+// Reader reads a SSTable using positional reads, so it's
+// safe to use from multiple goroutines at once.
 type Reader struct {
-	reader io.ReadSeeker
+	reader io.ReaderAt
 	length int64
 	index  []byte
+	filter *filterReader
+	data   []byte
+	cache  *blocks.Cache
+	file   uint64
+	offset int64
 }
 
-func NewReader(r io.ReadSeeker, length int64) (*Reader, error) {
-	footer := make([]byte, FOOTER_SIZE)
+// Opens the SSTable located in the first length bytes of r.
+func NewReader(r io.ReaderAt, length int64) (*Reader, error) {
+	return NewCachedReader(r, length, nil, 0, 0)
+}
+
+// Like NewReader, but the table's blocks, and footer, are looked up in
+// the cache before they're read from r, and added to it after. They're
+// identified by file, and their offset within the file, given the
+// table is found at offset within the file.
+func NewCachedReader(r io.ReaderAt, length int64, cache *blocks.Cache, file uint64, offset int64) (*Reader, error) {
+	return open(&Reader{
+		reader: r,
+		length: length,
+		cache:  cache,
+		file:   file,
+		offset: offset,
+	})
+}
 
-	r.Seek(length-int64(FOOTER_SIZE), 0)
-	_, err := r.Read(footer)
+// Opens the SSTable in b, which is read without copying. Values
+// are slices of b, so b mustn't be modified while it's being read.
+func NewSliceReader(b []byte) (*Reader, error) {
+	return open(&Reader{
+		reader: bytes.NewReader(b),
+		length: int64(len(b)),
+		data:   b,
+	})
+}
+
+func open(reader *Reader) (*Reader, error) {
+	length := reader.length
+
+	if length < int64(FOOTER_SIZE) {
+		return nil, errors.New("invalid sst format")
+	}
+
+	footer, err := reader.read(blockHandle{length - int64(FOOTER_SIZE), int64(FOOTER_SIZE)}, false)
 	if err != nil {
 		return nil, err
 	}
@@ -32,20 +77,58 @@ func NewReader(r io.ReadSeeker, length int64) (*Reader, error) {
 		return nil, errors.New("invalid sst format")
 	}
 
-	_, n := decodeBlockHandle(footer[:])
+	metaindexBlockHandle, n := decodeBlockHandle(footer[:])
 	indexBlockHandle, n := decodeBlockHandle(footer[n:])
 
-	reader := &Reader{
-		reader: r,
-		length: length,
+	if reader.index, err = reader.readBlock(indexBlockHandle); err != nil {
+		return reader, err
 	}
 
-	reader.index, err = reader.readBlock(indexBlockHandle)
+	err = reader.readFilter(metaindexBlockHandle)
 
 	return reader, err
 }
 
+// Reads the table's filter block, if it has one
+// built by any of the known filter policies.
+func (r *Reader) readFilter(metaindex blockHandle) error {
+	meta, err := r.readBlock(metaindex)
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range filterPolicies {
+		iter, err := seek(meta, []byte(filterMetaPrefix+policy.Name()))
+		if err != nil {
+			return err
+		}
+
+		if !iter.Next() || string(iter.Key()) != filterMetaPrefix+policy.Name() {
+			continue
+		}
+
+		handle, n := decodeBlockHandle(iter.Value())
+		if n == 0 {
+			return errors.New("sst: corrupt metaindex entry")
+		}
+
+		data, err := r.readBlock(handle)
+		if err != nil {
+			return err
+		}
+
+		r.filter = newFilterReader(policy, data)
+		return nil
+	}
+
+	return nil
+}
+
 func (r *Reader) Get(key []byte) (value []byte, err error) {
+	if !r.mayContain(key) {
+		return nil, errNotFound
+	}
+
 	iter, err := r.Find(key)
 	if err != nil {
 		return nil, err
@@ -53,7 +136,7 @@ func (r *Reader) Get(key []byte) (value []byte, err error) {
 
 	if !iter.Next() || string(key) != string(iter.Key()) {
 		if err = iter.Close(); err == nil {
-			err = errors.New("not found")
+			err = errNotFound
 		}
 
 		return
@@ -62,6 +145,26 @@ func (r *Reader) Get(key []byte) (value []byte, err error) {
 	return iter.Value(), iter.Close()
 }
 
+// Returns false if the table's filter rules out the key, without
+// reading the data block the key would be in.
+func (r *Reader) mayContain(key []byte) bool {
+	if r.filter == nil {
+		return true
+	}
+
+	index, err := seek(r.index, key)
+	if err != nil || !index.Next() {
+		return true
+	}
+
+	handle, n := decodeBlockHandle(index.Value())
+	if n == 0 {
+		return true
+	}
+
+	return r.filter.mayContain(handle.offset, key)
+}
+
 func (r *Reader) Find(key []byte) (Iterator, error) {
 	index, err := seek(r.index, key)
 
@@ -79,15 +182,72 @@ func (r *Reader) Find(key []byte) (Iterator, error) {
 	return iter, nil
 }
 
+// Reads a block and verifies it against the
+// checksum stored in the block's trailer.
 func (r *Reader) readBlock(handle blockHandle) ([]byte, error) {
-	bytes := make([]byte, handle.length)
+	return r.read(handle, true)
+}
 
-	r.reader.Seek(handle.offset, 0)
-	if _, err := r.reader.Read(bytes); err != nil {
-		return nil, err
+// Reads the data at the handle, from the cache if it's there.
+// Blocks are only cached once their checksum has been verified.
+func (r *Reader) read(handle blockHandle, block bool) ([]byte, error) {
+	key := blocks.CacheKey{File: r.file, Offset: r.offset + handle.offset}
+
+	if r.cache != nil {
+		if data, ok := r.cache.Get(key); ok {
+			return data, nil
+		}
+	}
+
+	length := handle.length
+	if block {
+		length += BlockTrailerLen
+	}
+
+	var bytes []byte
+
+	if r.data != nil {
+		if handle.offset < 0 || handle.offset+length > int64(len(r.data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		bytes = r.data[handle.offset : handle.offset+length : handle.offset+length]
+	} else {
+		bytes = make([]byte, length)
+
+		if _, err := readAt(r.reader, bytes, handle.offset); err != nil {
+			return nil, err
+		}
+	}
+
+	data := bytes[:handle.length]
+
+	if block {
+		trailer := bytes[handle.length:]
+
+		if binary.LittleEndian.Uint32(trailer[1:]) != checksum(data, trailer[0]) {
+			return nil, ErrChecksumMismatch
+		}
+	}
+
+	if r.cache != nil {
+		r.cache.Add(key, data)
+	}
+
+	return data, nil
+}
+
+// Reads exactly len(p) bytes at the given offset. Unlike a bare ReadAt,
+// reaching the end of the data after filling p isn't an error.
+func readAt(r io.ReaderAt, p []byte, offset int64) (int, error) {
+	n, err := r.ReadAt(p, offset)
+	if n == len(p) {
+		return n, nil
+	} else if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
 
-	return bytes, nil
+	return n, err
 }
 
 func seek(data []byte, key []byte) (*iterator, error) {
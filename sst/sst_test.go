@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/Pallinder/go-randomdata"
+	"github.com/customerio/esdb/blocks"
 )
 
 var once sync.Once
@@ -44,7 +45,19 @@ func create() (*bytes.Buffer, error) {
 
 func read(buf *bytes.Buffer) error {
 	r, _ := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	sliced, _ := NewSliceReader(buf.Bytes())
+	cached, _ := NewCachedReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), blocks.NewCache(1024), 1, 0)
 
+	for _, r := range []*Reader{r, sliced, cached, cached} {
+		if err := readAll(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readAll(r *Reader) error {
 	for key, val := range data {
 		if found, err := r.Get([]byte(key)); string(found) != string(val) || err != nil {
 			return fmt.Errorf("Key %q: wanted: %q, found: %q", key, val, found)
@@ -34,14 +34,34 @@ type Writer struct {
 	nEntries int
 	restarts []uint32
 	tmp      [50]byte
+
+	filter *filterWriter
+}
+
+type WriterOptions struct {
+	// Stores filters of the keys of each data block, so reads of
+	// keys which aren't in the table can usually skip reading any
+	// data blocks. Readers only use filters built by BloomFilter.
+	Filter FilterPolicy
 }
 
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{
+	return NewWriterWithOptions(w, WriterOptions{})
+}
+
+// Like NewWriter, but configured with the given options.
+func NewWriterWithOptions(w io.Writer, options WriterOptions) *Writer {
+	writer := &Writer{
 		writer:   w,
 		prevKey:  make([]byte, 0, 256),
 		restarts: make([]uint32, 0, 256),
 	}
+
+	if options.Filter != nil {
+		writer.filter = &filterWriter{policy: options.Filter}
+	}
+
+	return writer
 }
 
 func (w *Writer) Set(key, value []byte) error {
@@ -51,11 +71,15 @@ func (w *Writer) Set(key, value []byte) error {
 
 	w.flushPendingBH(key)
 
+	if w.filter != nil {
+		w.filter.addKey(key)
+	}
+
 	w.append(key, value, w.nEntries%BlockRestartInterval == 0)
 
 	// If the estimated block size is sufficiently large, finish the current block.
 	if w.buf.Len()+4*(len(w.restarts)+1) >= BlockSize {
-		bh, err := w.finishBlock()
+		bh, err := w.finishDataBlock()
 		if err != nil {
 			return err
 		}
@@ -70,7 +94,7 @@ func (w *Writer) Close() (err error) {
 	// aren't any data blocks at all.
 	w.flushPendingBH(nil)
 	if w.nEntries > 0 || len(w.indexEntries) == 0 {
-		bh, err := w.finishBlock()
+		bh, err := w.finishDataBlock()
 		if err != nil {
 			return err
 		}
@@ -78,7 +102,18 @@ func (w *Writer) Close() (err error) {
 		w.flushPendingBH(nil)
 	}
 
-	// Write the (empty) metaindex block.
+	// Write the filter block, and then the metaindex block,
+	// which is empty unless it locates the filter block.
+	if w.filter != nil {
+		bh, err := w.writeBlock(w.filter.finish())
+		if err != nil {
+			return err
+		}
+
+		n := encodeBlockHandle(w.tmp[3*binary.MaxVarintLen64:], bh)
+		w.append([]byte(filterMetaPrefix+w.filter.policy.Name()), w.tmp[3*binary.MaxVarintLen64:3*binary.MaxVarintLen64+n], true)
+	}
+
 	metaindexBlockHandle, err := w.finishBlock()
 	if err != nil {
 		return err
@@ -156,29 +191,54 @@ func (w *Writer) finishBlock() (blockHandle, error) {
 	binary.LittleEndian.PutUint32(tmp4, uint32(len(w.restarts)))
 	w.buf.Write(tmp4)
 
-	b := w.buf.Bytes()
-	w.tmp[0] = 0
+	bh, err := w.writeBlock(w.buf.Bytes())
+	if err != nil {
+		return blockHandle{}, err
+	}
+
+	w.buf.Reset()
+	w.nEntries = 0
+	w.restarts = w.restarts[:0]
+	return bh, nil
+}
+
+// Finishes a data block, starting the filter of the next.
+func (w *Writer) finishDataBlock() (blockHandle, error) {
+	bh, err := w.finishBlock()
+
+	if err == nil && w.filter != nil {
+		w.filter.startBlock(w.offset)
+	}
+
+	return bh, err
+}
 
-	checksum := crc32.Update(0, table, b)
-	checksum = crc32.Update(checksum, table, w.tmp[:1])
-	checksum = uint32(checksum>>15|checksum<<17) + 0xa282ead8
-	binary.LittleEndian.PutUint32(w.tmp[1:5], checksum)
+// Writes a block followed by its trailer.
+func (w *Writer) writeBlock(b []byte) (blockHandle, error) {
+	var trailer [BlockTrailerLen]byte
+
+	binary.LittleEndian.PutUint32(trailer[1:], checksum(b, trailer[0]))
 
 	if _, err := w.writer.Write(b); err != nil {
 		return blockHandle{}, err
 	}
-	if _, err := w.writer.Write(w.tmp[:5]); err != nil {
+	if _, err := w.writer.Write(trailer[:]); err != nil {
 		return blockHandle{}, err
 	}
 	bh := blockHandle{int64(w.offset), int64(len(b))}
 	w.offset += uint64(len(b)) + BlockTrailerLen
 
-	w.buf.Reset()
-	w.nEntries = 0
-	w.restarts = w.restarts[:0]
 	return bh, nil
 }
 
+// The masked CRC32C of a block's data and type byte,
+// as stored in the block's trailer.
+func checksum(b []byte, kind byte) uint32 {
+	c := crc32.Update(0, table, b)
+	c = crc32.Update(c, table, []byte{kind})
+	return uint32(c>>15|c<<17) + 0xa282ead8
+}
+
 func appendSeparator(dst, a, b []byte) []byte {
 	i, n := sharedPrefixLen(a, b), len(dst)
 	dst = append(dst, a...)
@@ -0,0 +1,218 @@
+package esdb
+
+import (
+	"io"
+	"strings"
+
+	"github.com/customerio/esdb/blocks"
+)
+
+// SectionStats describes the events of a grouping or index.
+type SectionStats struct {
+	// The number of events in the grouping or index.
+	Events int
+
+	// The size of the section's blocks in the file.
+	Bytes int64
+
+	// The number of blocks the section is stored in.
+	Blocks int
+
+	// The oldest and newest timestamps of the section's events,
+	// or 0 if it doesn't have any.
+	MinTimestamp int
+	MaxTimestamp int
+}
+
+// Records an event's timestamp in the stats.
+func (s *SectionStats) add(timestamp int) {
+	if s.Events == 0 || timestamp < s.MinTimestamp {
+		s.MinTimestamp = timestamp
+	}
+
+	if s.Events == 0 || timestamp > s.MaxTimestamp {
+		s.MaxTimestamp = timestamp
+	}
+
+	s.Events += 1
+}
+
+// SpaceStats describes each of the groupings and indexes of a space.
+type SpaceStats struct {
+	// Stats of each grouping by its name.
+	Groupings map[string]*SectionStats
+
+	// Stats of each index by its name, and then by its value.
+	Indexes map[string]map[string]*SectionStats
+}
+
+// Returns the names of the space's indexes, in sorted order.
+func (s *Space) Indexes() ([]string, error) {
+	names := make([]string, 0)
+
+	err := s.iterateSections("i", func(key string) bool {
+		name, _ := splitIndexKey(key)
+
+		if n := len(names); n == 0 || names[n-1] != name {
+			names = append(names, name)
+		}
+
+		return true
+	})
+
+	return names, err
+}
+
+// Returns the values of the given index, in sorted order.
+func (s *Space) IndexValues(name string) ([]string, error) {
+	values := make([]string, 0)
+
+	err := s.iterateSections("i"+name+":", func(key string) bool {
+		_, value := splitIndexKey(key)
+		values = append(values, value)
+		return true
+	})
+
+	return values, err
+}
+
+// Returns the stats of each of the space's groupings and indexes.
+//
+// Stats are stored with the space when it's written. For files
+// written before stats were stored, each section is read in full
+// to find its stats.
+func (s *Space) Stats() (*SpaceStats, error) {
+	stats := &SpaceStats{
+		Groupings: make(map[string]*SectionStats),
+		Indexes:   make(map[string]map[string]*SectionStats),
+	}
+
+	var err error
+
+	iterErr := s.iterateSections("", func(key string) bool {
+		var sec *section
+		var found *SectionStats
+
+		if sec, err = s.findSection(key); err != nil || sec == nil {
+			return false
+		}
+
+		if found, err = s.sectionStats(key, sec); err != nil {
+			return false
+		}
+
+		if strings.HasPrefix(key, "g") {
+			stats.Groupings[key[1:]] = found
+		} else if strings.HasPrefix(key, "i") {
+			name, value := splitIndexKey(key)
+
+			if stats.Indexes[name] == nil {
+				stats.Indexes[name] = make(map[string]*SectionStats)
+			}
+
+			stats.Indexes[name][value] = found
+		}
+
+		return true
+	})
+
+	if err == nil {
+		err = iterErr
+	}
+
+	return stats, err
+}
+
+// Calls process with each section key beginning with the prefix,
+// in sorted order, until process returns false.
+func (s *Space) iterateSections(prefix string, process func(key string) bool) error {
+	iter, err := s.index.Find([]byte(prefix))
+	if err != nil {
+		return err
+	}
+
+	for iter.Next() {
+		key := string(iter.Key())
+
+		if !strings.HasPrefix(key, prefix) || !process(key) {
+			break
+		}
+	}
+
+	return iter.Close()
+}
+
+// Index section keys are "i<name>:<value>".
+func splitIndexKey(key string) (name, value string) {
+	parts := strings.SplitN(key[1:], ":", 2)
+
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// Returns the section's stored stats, or reads the section
+// to find them if the file doesn't contain them.
+func (s *Space) sectionStats(key string, sec *section) (*SectionStats, error) {
+	if sec.stats != nil {
+		stats := *sec.stats
+		return &stats, nil
+	}
+
+	stats := &SectionStats{Bytes: sec.length}
+
+	var iter EventIterator
+	if strings.HasPrefix(key, "g") {
+		iter = s.Events(key[1:])
+	} else {
+		iter = s.IndexEvents(splitIndexKey(key))
+	}
+
+	for iter.Next() {
+		stats.add(iter.Event().Timestamp)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	blockCount, err := s.countBlocks(sec)
+	stats.Blocks = blockCount
+
+	return stats, err
+}
+
+// Counts the blocks of a section by stepping from
+// the header of each block to the next.
+func (s *Space) countBlocks(sec *section) (int, error) {
+	r := s.sectionReader(sec.offset, sec.length)
+	head := make([]byte, s.header.blockHeaderLen())
+	count := 0
+
+	for offset := int64(0); offset < sec.length; count++ {
+		if _, err := r.ReadAt(head, offset); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return count, err
+		}
+
+		// Each block begins with its little endian
+		// length, and then the block's encoding.
+		length := int64(0)
+		for i := len(head) - 2; i >= 0; i-- {
+			length = length<<8 | int64(head[i])
+		}
+
+		offset += int64(len(head)) + length
+
+		if head[len(head)-1]&blocks.CHECKSUM_FLAG != 0 {
+			offset += 4
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,67 @@
+package esdb
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSpaceIndexNames(t *testing.T) {
+	space := createQueried(currentHeader(), 100)
+
+	if names, err := space.Indexes(); err != nil || !reflect.DeepEqual(names, []string{"mod2", "mod3", "mod5"}) {
+		t.Errorf("Wrong index names: wanted: [mod2 mod3 mod5] found: %v %v", names, err)
+	}
+
+	if values, err := space.IndexValues("mod3"); err != nil || !reflect.DeepEqual(values, []string{"0", "1", "2"}) {
+		t.Errorf("Wrong index values: wanted: [0 1 2] found: %v %v", values, err)
+	}
+
+	if values, err := space.IndexValues("mod"); err != nil || len(values) != 0 {
+		t.Errorf("Wrong index values: wanted: [] found: %v %v", values, err)
+	}
+}
+
+func TestSpaceStats(t *testing.T) {
+	count := 3000
+	space := createQueried(currentHeader(), count)
+
+	stats, err := space.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grouping := stats.Groupings["g"]
+
+	if grouping == nil || grouping.Events != count || grouping.MinTimestamp != 0 || grouping.MaxTimestamp != (count-1)/4 {
+		t.Errorf("Wrong grouping stats: %+v", grouping)
+	}
+
+	if sec, _ := space.findSection("gg"); grouping == nil || grouping.Bytes != sec.length || grouping.Blocks < 2 {
+		t.Errorf("Wrong grouping size: %+v", grouping)
+	}
+
+	if len(stats.Indexes) != 3 || len(stats.Indexes["mod5"]) != 5 {
+		t.Errorf("Wrong indexes: %v", stats.Indexes)
+	}
+
+	for i := 0; i < 5; i++ {
+		index := stats.Indexes["mod5"][strconv.Itoa(i)]
+
+		if index == nil || index.Events != count/5 || index.MinTimestamp != i/4 || index.MaxTimestamp != (count-5+i)/4 {
+			t.Errorf("Wrong index stats for mod5=%d: %+v", i, index)
+		}
+	}
+
+	// Files written before stats were stored have their
+	// sections read instead, which should find the same stats.
+	for _, key := range []string{"gg", "imod2:0", "imod5:4"} {
+		sec, _ := space.findSection(key)
+		stored := sec.stats
+		sec.stats = nil
+
+		if found, err := space.sectionStats(key, sec); err != nil || !reflect.DeepEqual(found, stored) {
+			t.Errorf("Wrong stats read from %s:\n wanted: %+v\n found:  %+v %v", key, stored, found, err)
+		}
+	}
+}
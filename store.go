@@ -0,0 +1,638 @@
+package esdb
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/customerio/esdb/blocks"
+)
+
+// The name of the file in a store's directory
+// listing the store's files.
+const manifestName = "MANIFEST"
+
+// Store reads a directory of .esdb files as one, such as files
+// partitioned by time. Its manifest lists the files, along with the
+// range of timestamps of their events and of their space ids, so
+// finding spaces and scanning their events only reads the files
+// which could contain them.
+//
+// Files are added to and retired from the store atomically, by
+// replacing its manifest. Files in the directory which aren't in the
+// manifest are ignored, so new files can be written to the directory,
+// such as by Merge, before they're added.
+//
+// Store is safe to use from multiple goroutines, as are the spaces
+// it returns, and files can be added and retired while spaces are
+// being scanned.
+type Store struct {
+	dir       string
+	cache     *blocks.Cache
+	precision Precision
+	files     []*storeFile
+	lock      sync.RWMutex
+
+	// Held while the manifest is being replaced.
+	update sync.Mutex
+}
+
+// StoreFile describes a file of a store, as listed in its manifest.
+type StoreFile struct {
+	// The name of the file within the store's directory.
+	Name string `json:"name"`
+
+	// The oldest and newest timestamps of the file's
+	// events, or 0 if it doesn't have any.
+	MinTimestamp int `json:"min_timestamp"`
+	MaxTimestamp int `json:"max_timestamp"`
+
+	// The number of spaces in the file,
+	// and the first and last of their ids.
+	Spaces     int    `json:"spaces"`
+	FirstSpace []byte `json:"first_space"`
+	LastSpace  []byte `json:"last_space"`
+}
+
+type manifest struct {
+	Precision Precision   `json:"precision"`
+	Files     []StoreFile `json:"files"`
+}
+
+// An open file of a store. Files are closed once they've been
+// retired, and are no longer being read.
+type storeFile struct {
+	StoreFile
+	db      *Db
+	refs    int
+	retired bool
+	lock    sync.Mutex
+}
+
+// Opens the store in a directory. A directory
+// without a manifest is an empty store.
+func OpenStore(dir string) (*Store, error) {
+	return OpenStoreWithOptions(dir, OpenOptions{})
+}
+
+// Opens the store in a directory, with its files opened with the
+// given options. The block cache is shared by all of the files.
+func OpenStoreWithOptions(dir string, options OpenOptions) (*Store, error) {
+	cache, err := options.newCache()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, cache: cache}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("esdb: invalid store manifest: %v", err)
+	}
+
+	s.precision = m.Precision
+
+	for _, info := range m.Files {
+		db, err := openFile(filepath.Join(dir, info.Name), s.cache)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		s.files = append(s.files, &storeFile{StoreFile: info, db: db})
+	}
+
+	return s, nil
+}
+
+// Returns the files of the store, in the order they were added.
+func (s *Store) Files() []StoreFile {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	files := make([]StoreFile, len(s.files))
+	for i, f := range s.files {
+		files[i] = f.StoreFile
+	}
+
+	return files
+}
+
+// Returns the unit of the timestamps of the store's events,
+// which all of its files have.
+func (s *Store) Precision() Precision {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.precision
+}
+
+// Returns the hits and misses of the block cache shared by the
+// store's files, and what it holds. They're all zero if the store
+// was opened without a block cache.
+func (s *Store) CacheStats() blocks.CacheStats {
+	if s.cache == nil {
+		return blocks.CacheStats{}
+	}
+
+	return s.cache.Stats()
+}
+
+// Adds files, which must already be in the store's directory.
+func (s *Store) Add(names ...string) error {
+	return s.Update(names, nil)
+}
+
+// Retires files, which remain in the store's directory.
+func (s *Store) Retire(names ...string) error {
+	return s.Update(nil, names)
+}
+
+// Adds and retires files at once, such as to replace files with a
+// file they were merged into. Spaces found afterwards, and scans
+// begun afterwards, read the added files and not the retired ones.
+// Retired files are closed once any scans of them have finished.
+//
+// Added files must be in the store's directory, and have the same
+// precision as the store's other files.
+func (s *Store) Update(add, retire []string) (err error) {
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	s.lock.RLock()
+	current := s.files
+	precision := s.precision
+	s.lock.RUnlock()
+
+	files := make([]*storeFile, 0, len(current)+len(add))
+	retiring := make(map[string]bool)
+	names := make(map[string]bool)
+
+	for _, f := range current {
+		names[f.Name] = true
+	}
+
+	for _, name := range retire {
+		if !names[name] {
+			return fmt.Errorf("esdb: can't retire %s, which isn't in the store", name)
+		}
+
+		retiring[name] = true
+	}
+
+	for _, f := range current {
+		if retiring[f.Name] {
+			delete(names, f.Name)
+		} else {
+			files = append(files, f)
+		}
+	}
+
+	added := make([]*storeFile, 0, len(add))
+
+	defer func() {
+		if err != nil {
+			for _, f := range added {
+				f.db.Close()
+			}
+		}
+	}()
+
+	for _, name := range add {
+		if filepath.Base(name) != name {
+			return fmt.Errorf("esdb: can't add %s, which isn't the name of a file in the store's directory", name)
+		}
+
+		if names[name] {
+			return fmt.Errorf("esdb: can't add %s, which is already in the store", name)
+		}
+
+		db, err := openFile(filepath.Join(s.dir, name), s.cache)
+		if err != nil {
+			return err
+		}
+
+		f := &storeFile{db: db}
+		added = append(added, f)
+
+		if f.StoreFile, err = describeFile(name, db); err != nil {
+			return err
+		}
+
+		if len(files) == 0 {
+			precision = db.Precision()
+		} else if db.Precision() != precision {
+			return fmt.Errorf("esdb: can't add %s with %s timestamps to a store with %s timestamps", name, db.Precision(), precision)
+		}
+
+		files = append(files, f)
+		names[name] = true
+	}
+
+	if err = s.writeManifest(precision, files); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.files = files
+	s.precision = precision
+	s.lock.Unlock()
+
+	for _, f := range current {
+		if retiring[f.Name] {
+			f.retire()
+		}
+	}
+
+	return nil
+}
+
+// Replaces the manifest, by writing the new manifest to
+// a temporary file which is renamed over the old one.
+func (s *Store) writeManifest(precision Precision, files []*storeFile) error {
+	m := manifest{Precision: precision, Files: make([]StoreFile, len(files))}
+
+	for i, f := range files {
+		m.Files[i] = f.StoreFile
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, manifestName+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(append(b, '\n')); err == nil {
+		err = tmp.Sync()
+	}
+
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.dir, manifestName))
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// The rename is only durable once the directory is synced.
+	return syncDir(s.dir)
+}
+
+// Describes a file for the manifest, from the ids of its spaces
+// and the stats of their groupings.
+func describeFile(name string, db *Db) (StoreFile, error) {
+	info := StoreFile{Name: name}
+	events := 0
+
+	var err error
+
+	iterErr := db.Iterate(func(space *Space) bool {
+		if space == nil {
+			err = fmt.Errorf("esdb: %s: couldn't open a space", name)
+			return false
+		}
+
+		if info.Spaces == 0 {
+			info.FirstSpace = append([]byte(nil), space.Id...)
+		}
+
+		info.LastSpace = append(info.LastSpace[:0], space.Id...)
+		info.Spaces += 1
+
+		err = space.iterateSections("g", func(key string) bool {
+			var sec *section
+			var stats *SectionStats
+
+			if sec, err = space.findSection(key); err != nil || sec == nil {
+				return false
+			}
+
+			if stats, err = space.sectionStats(key, sec); err != nil {
+				return false
+			}
+
+			if stats.Events > 0 {
+				if events == 0 || stats.MinTimestamp < info.MinTimestamp {
+					info.MinTimestamp = stats.MinTimestamp
+				}
+
+				if events == 0 || stats.MaxTimestamp > info.MaxTimestamp {
+					info.MaxTimestamp = stats.MaxTimestamp
+				}
+
+				events += stats.Events
+			}
+
+			return true
+		})
+
+		return err == nil
+	})
+
+	if err == nil {
+		err = iterErr
+	}
+
+	return info, err
+}
+
+// Closes all of the store's files, once
+// any scans of them have finished.
+func (s *Store) Close() {
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	s.lock.Lock()
+	files := s.files
+	s.files = nil
+	s.lock.Unlock()
+
+	for _, f := range files {
+		f.retire()
+	}
+}
+
+// Marks a file as being read, returning false if it's been retired.
+func (f *storeFile) acquire() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.retired {
+		return false
+	}
+
+	f.refs += 1
+	return true
+}
+
+func (f *storeFile) release() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.refs -= 1
+
+	if f.retired && f.refs == 0 {
+		f.db.Close()
+	}
+}
+
+func (f *storeFile) retire() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.retired = true
+
+	if f.refs == 0 {
+		f.db.Close()
+	}
+}
+
+// Returns true if the file could have events with
+// timestamps between from and to (inclusive).
+func (f *storeFile) overlaps(from, to int) bool {
+	return f.Spaces > 0 && f.MinTimestamp <= to && f.MaxTimestamp >= from
+}
+
+// StoreSpace is a space of a store, made up of the same
+// space in each of the store's files which contain it.
+type StoreSpace struct {
+	Id []byte
+
+	files  []*storeFile
+	spaces []*Space
+}
+
+// Finds a space by its id in each of the store's files, returning
+// nil if none of them contain it. Files whose range of space ids
+// doesn't include the id aren't read.
+func (s *Store) Find(id []byte) *StoreSpace {
+	s.lock.RLock()
+	files := s.files
+	s.lock.RUnlock()
+
+	found := &StoreSpace{Id: id}
+
+	for _, f := range files {
+		if f.Spaces == 0 || bytes.Compare(id, f.FirstSpace) < 0 || bytes.Compare(id, f.LastSpace) > 0 {
+			continue
+		}
+
+		if !f.acquire() {
+			continue
+		}
+
+		if space := f.db.Find(id); space != nil {
+			found.files = append(found.files, f)
+			found.spaces = append(found.spaces, space)
+		}
+
+		f.release()
+	}
+
+	if len(found.spaces) == 0 {
+		return nil
+	}
+
+	return found
+}
+
+// Returns an iterator over the events of a grouping
+// in all of the space's files, newest first.
+func (s *StoreSpace) Events(grouping string) EventIterator {
+	return s.EventsRange(grouping, math.MinInt64, math.MaxInt64)
+}
+
+// Returns an iterator over the events of a grouping in all of the
+// space's files with timestamps between from and to (inclusive),
+// newest first. Files without any events in the range aren't read.
+func (s *StoreSpace) EventsRange(grouping string, from, to int) EventIterator {
+	return s.merge(from, to, func(space *Space) EventIterator {
+		return space.EventsRange(grouping, from, to)
+	})
+}
+
+// Returns an iterator over the events of an index
+// in all of the space's files, newest first.
+func (s *StoreSpace) IndexEvents(name, value string) EventIterator {
+	return s.IndexEventsRange(name, value, math.MinInt64, math.MaxInt64)
+}
+
+// Returns an iterator over the events of an index in all of the
+// space's files with timestamps between from and to (inclusive),
+// newest first. Files without any events in the range aren't read.
+func (s *StoreSpace) IndexEventsRange(name, value string, from, to int) EventIterator {
+	return s.merge(from, to, func(space *Space) EventIterator {
+		return space.IndexEventsRange(name, value, from, to)
+	})
+}
+
+func (s *StoreSpace) Scan(grouping string, scanner Scanner) error {
+	return scan(s.Events(grouping), scanner)
+}
+
+// Scans the events of a grouping with timestamps between from and
+// to (inclusive), newest first.
+func (s *StoreSpace) ScanRange(grouping string, from, to int, scanner Scanner) error {
+	return scan(s.EventsRange(grouping, from, to), scanner)
+}
+
+func (s *StoreSpace) ScanIndex(name, value string, scanner Scanner) error {
+	return scan(s.IndexEvents(name, value), scanner)
+}
+
+// Scans the events of an index with timestamps between from and
+// to (inclusive), newest first.
+func (s *StoreSpace) ScanIndexRange(name, value string, from, to int, scanner Scanner) error {
+	return scan(s.IndexEventsRange(name, value, from, to), scanner)
+}
+
+// Merges iterators over each of the files with events in the range,
+// which haven't been retired. The files are read until the merged
+// iterator is closed.
+func (s *StoreSpace) merge(from, to int, events func(*Space) EventIterator) EventIterator {
+	m := &mergedIterator{}
+
+	for i, f := range s.files {
+		if !f.overlaps(from, to) || !f.acquire() {
+			continue
+		}
+
+		m.files = append(m.files, f)
+		m.push(&mergedHead{iter: events(s.spaces[i]), rank: i})
+	}
+
+	return m
+}
+
+// Merges the events of several iterators, newest first. Events with
+// the same timestamp are ordered by the rank of their iterator.
+type mergedIterator struct {
+	heads   []*mergedHead
+	current *mergedHead
+	files   []*storeFile
+	event   *Event
+	err     error
+	done    bool
+}
+
+type mergedHead struct {
+	iter  EventIterator
+	event *Event
+	rank  int
+}
+
+// Adds the iterator's next event to the heap, or closes it
+// once it's exhausted.
+func (m *mergedIterator) push(head *mergedHead) {
+	if head.iter.Next() {
+		head.event = head.iter.Event()
+		heap.Push(m, head)
+		return
+	}
+
+	if err := head.iter.Close(); err != nil && m.err == nil {
+		m.err = err
+	}
+}
+
+func (m *mergedIterator) Next() bool {
+	if m.current != nil {
+		m.push(m.current)
+		m.current = nil
+	}
+
+	if m.done || m.err != nil || len(m.heads) == 0 {
+		m.Close()
+		return false
+	}
+
+	m.current = heap.Pop(m).(*mergedHead)
+	m.event = m.current.event
+
+	return true
+}
+
+func (m *mergedIterator) Event() *Event {
+	return m.event
+}
+
+func (m *mergedIterator) Err() error {
+	return m.err
+}
+
+func (m *mergedIterator) Close() error {
+	if m.done {
+		return m.err
+	}
+
+	m.done = true
+	m.event = nil
+
+	if m.current != nil {
+		m.heads = append(m.heads, m.current)
+		m.current = nil
+	}
+
+	for _, head := range m.heads {
+		if err := head.iter.Close(); err != nil && m.err == nil {
+			m.err = err
+		}
+	}
+
+	m.heads = nil
+
+	for _, f := range m.files {
+		f.release()
+	}
+
+	m.files = nil
+
+	return m.err
+}
+
+// Implements heap.Interface.
+func (m *mergedIterator) Len() int      { return len(m.heads) }
+func (m *mergedIterator) Swap(i, j int) { m.heads[i], m.heads[j] = m.heads[j], m.heads[i] }
+
+func (m *mergedIterator) Less(i, j int) bool {
+	a, b := m.heads[i], m.heads[j]
+
+	if a.event.Timestamp != b.event.Timestamp {
+		return a.event.Timestamp > b.event.Timestamp
+	}
+
+	return a.rank < b.rank
+}
+
+func (m *mergedIterator) Push(x interface{}) {
+	m.heads = append(m.heads, x.(*mergedHead))
+}
+
+func (m *mergedIterator) Pop() interface{} {
+	n := len(m.heads)
+	head := m.heads[n-1]
+	m.heads = m.heads[:n-1]
+	return head
+}
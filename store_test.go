@@ -0,0 +1,323 @@
+package esdb
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// Writes the visits partitioned by time into files of the store's
+// directory, returning their names, oldest first.
+func writePartitions(t *testing.T, dir string, visits []visit, parts int) []string {
+	min, max := visits[0].Timestamp, visits[0].Timestamp
+	for _, v := range visits {
+		if v.Timestamp < min {
+			min = v.Timestamp
+		}
+
+		if v.Timestamp > max {
+			max = v.Timestamp
+		}
+	}
+
+	partitions := make([][]visit, parts)
+	for _, v := range visits {
+		part := (v.Timestamp - min) * parts / (max - min + 1)
+		partitions[part] = append(partitions[part], v)
+	}
+
+	names := make([]string, parts)
+	for i, part := range partitions {
+		names[i] = "part" + strconv.Itoa(i) + ".esdb"
+		writeVisits(t, dir+"/"+names[i], part, WriterOptions{}, "")
+	}
+
+	return names
+}
+
+func scanStore(s *Store, id, grouping string) []string {
+	found := make([]string, 0)
+
+	if space := s.Find([]byte(id)); space != nil {
+		space.Scan(grouping, func(e *Event) bool {
+			found = append(found, string(e.Data))
+			return true
+		})
+	}
+
+	return found
+}
+
+func scanStoreIndex(s *Store, id, index, value string) []string {
+	found := make([]string, 0)
+
+	if space := s.Find([]byte(id)); space != nil {
+		space.ScanIndex(index, value, func(e *Event) bool {
+			found = append(found, string(e.Data))
+			return true
+		})
+	}
+
+	return found
+}
+
+// Checks scans of the store find the same events as scans of the file.
+func compareStore(t *testing.T, name string, s *Store, db *Db, visits []visit) {
+	for _, v := range visits[:50] {
+		if expected, found := fetchSpaceGrouping(db, []byte(v.City), v.Host), scanStore(s, v.City, v.Host); !reflect.DeepEqual(found, expected) {
+			t.Errorf("%s: %s %s: wanted %d events, found %d", name, v.City, v.Host, len(expected), len(found))
+		}
+
+		if expected, found := fetchSpaceIndex(db, []byte(v.City), "visitor", v.Visitor), scanStoreIndex(s, v.City, "visitor", v.Visitor); !reflect.DeepEqual(found, expected) {
+			t.Errorf("%s: %s visitor %s: wanted %d events, found %d", name, v.City, v.Visitor, len(expected), len(found))
+		}
+	}
+
+	if space := s.Find([]byte("absent")); space != nil {
+		t.Errorf("%s: expected absent space not to be found", name)
+	}
+}
+
+func TestStore(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+	writeVisits(t, "tmp/unpartitioned.esdb", visits, WriterOptions{}, "")
+
+	db, err := Open("tmp/unpartitioned.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	os.MkdirAll("tmp/store", 0755)
+	names := writePartitions(t, "tmp/store", visits, 3)
+
+	s, err := OpenStore("tmp/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files := s.Files(); len(files) != 0 {
+		t.Errorf("Expected an empty store, found: %v", files)
+	}
+
+	if err = s.Add(names...); err != nil {
+		t.Fatal(err)
+	}
+
+	compareStore(t, "added", s, db, visits)
+
+	files := s.Files()
+
+	for i, f := range files {
+		if f.Name != names[i] || f.Spaces == 0 || f.MinTimestamp == 0 || f.MaxTimestamp < f.MinTimestamp {
+			t.Errorf("Wrong description of %s: %+v", names[i], f)
+		}
+
+		if i > 0 && f.MinTimestamp <= files[i-1].MaxTimestamp {
+			t.Errorf("Overlapping partitions: %+v %+v", files[i-1], f)
+		}
+	}
+
+	s.Close()
+
+	// The manifest lists the files when the store is reopened.
+	s, err = OpenStore("tmp/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if found := s.Files(); !reflect.DeepEqual(found, files) {
+		t.Errorf("Wrong files in the manifest: wanted: %+v found: %+v", files, found)
+	}
+
+	compareStore(t, "reopened", s, db, visits)
+}
+
+func TestStoreSkipsFiles(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+
+	os.MkdirAll("tmp/store", 0755)
+	names := writePartitions(t, "tmp/store", visits, 3)
+
+	s, err := OpenStore("tmp/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err = s.Add(names...); err != nil {
+		t.Fatal(err)
+	}
+
+	files := s.Files()
+	space := s.Find([]byte("Kingsbridge"))
+
+	var tests = []struct {
+		from, to int
+		files    int
+		events   bool
+	}{
+		{files[0].MinTimestamp, files[2].MaxTimestamp, 3, true},
+		{files[1].MinTimestamp, files[1].MaxTimestamp, 1, true},
+		{files[1].MaxTimestamp, files[2].MinTimestamp, 2, false},
+		{files[2].MaxTimestamp + 1, files[2].MaxTimestamp + 100, 0, false},
+	}
+
+	for i, test := range tests {
+		iter := space.IndexEventsRange("type", "visit", test.from, test.to).(*mergedIterator)
+
+		if len(iter.files) != test.files {
+			t.Errorf("Case %d: wrong number of files read: wanted: %d found: %d", i, test.files, len(iter.files))
+		}
+
+		previous, count := test.to, 0
+		for iter.Next() {
+			count += 1
+
+			if e := iter.Event(); e.Timestamp > previous || e.Timestamp < test.from {
+				t.Errorf("Case %d: event with timestamp %d out of order, or outside of the range", i, e.Timestamp)
+			}
+
+			previous = iter.Event().Timestamp
+		}
+
+		if err := iter.Close(); err != nil || test.events && count == 0 || test.files == 0 && count > 0 {
+			t.Errorf("Case %d: wrong number of events: %d %v", i, count, err)
+		}
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+	writeVisits(t, "tmp/unpartitioned.esdb", visits, WriterOptions{}, "")
+
+	db, err := Open("tmp/unpartitioned.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	os.MkdirAll("tmp/store", 0755)
+	names := writePartitions(t, "tmp/store", visits, 3)
+
+	s, err := OpenStore("tmp/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err = s.Add(names...); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := scanStoreIndex(s, "Kingsbridge", "type", "visit")
+
+	// Begin a scan of the files which are retired.
+	iter := s.Find([]byte("Kingsbridge")).IndexEvents("type", "visit")
+	if !iter.Next() {
+		t.Fatalf("Expected events: %v", iter.Err())
+	}
+
+	retired := s.files[:2]
+
+	err = Merge("tmp/store/merged.esdb", []string{"tmp/store/" + names[0], "tmp/store/" + names[1]}, WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Update([]string{"merged.esdb"}, names[:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := s.Files(); len(files) != 2 || files[0].Name != names[2] || files[1].Name != "merged.esdb" {
+		t.Errorf("Wrong files after update: %+v", files)
+	}
+
+	compareStore(t, "updated", s, db, visits)
+
+	// Retired files are still read by the scan which began before
+	// they were retired, and closed once it's finished.
+	found := []string{string(iter.Event().Data)}
+	for iter.Next() {
+		found = append(found, string(iter.Event().Data))
+	}
+
+	if err = iter.Close(); err != nil || !reflect.DeepEqual(found, expected) {
+		t.Errorf("Wrong events from scan across the update: wanted %d events, found %d: %v", len(expected), len(found), err)
+	}
+
+	for _, f := range retired {
+		if !f.retired || f.refs != 0 {
+			t.Errorf("Expected %s to be retired and closed: %v %d", f.Name, f.retired, f.refs)
+		}
+	}
+
+	reopened, err := OpenStore("tmp/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if !reflect.DeepEqual(reopened.Files(), s.Files()) {
+		t.Errorf("Wrong files in the manifest: wanted: %+v found: %+v", s.Files(), reopened.Files())
+	}
+}
+
+func TestStoreUpdateErrors(t *testing.T) {
+	os.RemoveAll("tmp/errors")
+	os.MkdirAll("tmp/errors", 0755)
+
+	for _, name := range []string{"a.esdb", "b.esdb"} {
+		w, _ := New("tmp/errors/" + name)
+		w.Add([]byte("a"), []byte("1"), 1, "g", nil)
+		w.Write()
+	}
+
+	w, _ := NewWithOptions("tmp/errors/precise.esdb", WriterOptions{Precision: Milliseconds})
+	w.Add([]byte("a"), []byte("1"), 1, "g", nil)
+	w.Write()
+
+	s, err := OpenStore("tmp/errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err = s.Add("a.esdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		add, retire []string
+	}{
+		{[]string{"missing.esdb"}, nil},
+		{[]string{"a.esdb"}, nil},
+		{[]string{"b.esdb", "b.esdb"}, nil},
+		{[]string{"../errors/b.esdb"}, nil},
+		{[]string{"precise.esdb"}, nil},
+		{nil, []string{"b.esdb"}},
+		{[]string{"b.esdb"}, []string{"missing.esdb"}},
+	}
+
+	for i, test := range tests {
+		if err := s.Update(test.add, test.retire); err == nil {
+			t.Errorf("Case %d: expected error", i)
+		}
+
+		if files := s.Files(); len(files) != 1 || files[0].Name != "a.esdb" {
+			t.Errorf("Case %d: store was updated: %+v", i, files)
+		}
+	}
+
+	// Once every file is retired, files with
+	// any precision can be added.
+	if err = s.Update([]string{"precise.esdb"}, []string{"a.esdb"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Precision() != Milliseconds {
+		t.Errorf("Wrong precision: %v", s.Precision())
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"os"
 
 	"github.com/customerio/esdb/binary"
-	"github.com/customerio/esdb/bounded"
+	"github.com/customerio/esdb/blocks"
 	"github.com/customerio/esdb/sst"
 )
 
@@ -16,6 +16,7 @@ var WRITING_TO_CLOSED_STREAM = errors.New("stream has been closed")
 type closedStream struct {
 	stream io.ReaderAt
 	index  *sst.Reader
+	events blocks.Codec
 }
 
 func readonly(path string) (Stream, error) {
@@ -24,16 +25,16 @@ func readonly(path string) (Stream, error) {
 		return nil, err
 	}
 
-	header := binary.ReadBytes(file, int64(len(MAGIC_HEADER)))
-
-	if string(header) != string(MAGIC_HEADER) {
-		return nil, CORRUPTED_HEADER
+	codec, _, err := readHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
-	return newClosedStream(file)
+	return newClosedStream(file, codec)
 }
 
-func newClosedStream(stream *os.File) (Stream, error) {
+func newClosedStream(stream *os.File, codec blocks.Codec) (Stream, error) {
 	index, err := findIndex(stream)
 	if err != nil {
 		return nil, err
@@ -42,6 +43,7 @@ func newClosedStream(stream *os.File) (Stream, error) {
 	return &closedStream{
 		stream: stream,
 		index:  index,
+		events: codec,
 	}, nil
 }
 
@@ -103,11 +105,21 @@ func (s *closedStream) reader() io.ReaderAt {
 	return s.stream
 }
 
+func (s *closedStream) codec() blocks.Codec {
+	return s.events
+}
+
 func findIndex(f *os.File) (*sst.Reader, error) {
-	// The last 8 bytes in the file is the length
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	footerOffset := stat.Size() - FOOTER_LENGTH - 8
+
+	// The 8 bytes before the footer is the length
 	// of the SSTable spaces index.
-	f.Seek(-FOOTER_LENGTH-8, 2)
-	indexLen := binary.ReadInt64(f)
+	indexLen := binary.ReadInt64At(f, footerOffset)
 
-	return sst.NewReader(bounded.New(f, -8-FOOTER_LENGTH-indexLen, -8-FOOTER_LENGTH), indexLen)
+	return sst.NewReader(io.NewSectionReader(f, footerOffset-indexLen, indexLen), indexLen)
 }
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/customerio/esdb/binary"
+	"github.com/customerio/esdb/blocks"
 )
 
 var CORRUPTED_EVENT = errors.New("corrupted event")
@@ -14,6 +16,9 @@ var CORRUPTED_EVENT = errors.New("corrupted event")
 type Event struct {
 	Data    []byte
 	offsets map[string]int64
+
+	// The number of bytes the event was stored in.
+	size int
 }
 
 func NewEvent(data []byte, offsets map[string]int64) *Event {
@@ -37,18 +42,24 @@ func (e *Event) Indexes() map[string]string {
 
 // Events are encoded in the following byte format:
 // [int32:length][bytes(length):data]
-func (e *Event) push(buf *bytes.Buffer) (int, error) {
+//
+// In compressed streams, the data is compressed by the stream's codec.
+func (e *Event) push(buf *bytes.Buffer, codec blocks.Codec) (int, error) {
 	data := e.encode()
+
+	if codec != nil {
+		var err error
+		if data, err = codec.Encode(data); err != nil {
+			return 0, err
+		}
+	}
+
 	binary.WriteInt32(buf, len(data))
 	buf.Write(data)
 
 	return len(data) + 4, nil
 }
 
-func (e *Event) length() int {
-	return len(e.encode()) + 4
-}
-
 func (e *Event) encode() []byte {
 	buf := bytes.NewBuffer([]byte{})
 
@@ -57,10 +68,19 @@ func (e *Event) encode() []byte {
 
 	binary.WriteUvarint(buf, len(e.offsets))
 
-	for name, offset := range e.offsets {
+	// Offsets are written ordered by index, so the
+	// same event is always encoded the same way.
+	names := make(sort.StringSlice, 0, len(e.offsets))
+	for name := range e.offsets {
+		names = append(names, name)
+	}
+
+	names.Sort()
+
+	for _, name := range names {
 		binary.WriteUvarint(buf, len(name))
 		buf.Write([]byte(name))
-		binary.WriteUvarint64(buf, offset)
+		binary.WriteUvarint64(buf, e.offsets[name])
 	}
 
 	return buf.Bytes()
@@ -87,7 +107,7 @@ func decodeEvent(b []byte) (*Event, error) {
 	return NewEvent(data, offsets), nil
 }
 
-func pullEvent(r io.ReaderAt, offset int64) (*Event, error) {
+func pullEvent(r io.ReaderAt, offset int64, codec blocks.Codec) (*Event, error) {
 	if size := binary.ReadInt32At(r, offset); size > 0 {
 		offset += 4
 
@@ -97,7 +117,21 @@ func pullEvent(r io.ReaderAt, offset int64) (*Event, error) {
 			return nil, CORRUPTED_EVENT
 		}
 
-		return decodeEvent(data)
+		decoded := data
+
+		if codec != nil {
+			var err error
+			if decoded, err = codec.Decode(nil, data); err != nil {
+				return nil, CORRUPTED_EVENT
+			}
+		}
+
+		event, err := decodeEvent(decoded)
+		if err == nil {
+			event.size = len(data) + 4
+		}
+
+		return event, err
 	} else {
 		return nil, io.EOF
 	}
@@ -9,6 +9,7 @@ import (
 	"sync"
 
 	"github.com/customerio/esdb/binary"
+	"github.com/customerio/esdb/blocks"
 	"github.com/customerio/esdb/sst"
 )
 
@@ -20,6 +21,7 @@ type openStream struct {
 	closed   bool
 	offset   int64
 	length   int
+	events   blocks.Codec
 	initlock sync.Once
 }
 
@@ -33,16 +35,31 @@ func read(path string) (Stream, error) {
 }
 
 func createOpenStream(stream Streamer) (Stream, error) {
-	offset, err := stream.WriteAt([]byte(MAGIC_HEADER), 0)
+	return createOpenStreamWithOptions(stream, Options{})
+}
+
+func createOpenStreamWithOptions(stream Streamer, options Options) (Stream, error) {
+	codec := options.Codec
+	if codec != nil && codec.Encoding() == blocks.NO_COMPRESSION {
+		codec = nil
+	}
+
+	offset, err := writeHeader(stream, codec)
 	if err != nil {
 		return nil, err
 	}
 
-	return &openStream{
+	s := &openStream{
 		stream: stream,
 		tails:  make(map[string]int64),
 		offset: int64(offset),
-	}, nil
+		events: codec,
+	}
+
+	// There's nothing to populate from a new stream.
+	s.initlock.Do(func() {})
+
+	return s, nil
 }
 
 func newOpenStream(stream Streamer) Stream {
@@ -50,6 +67,10 @@ func newOpenStream(stream Streamer) Stream {
 }
 
 func Serialize(data []byte, indexes map[string]string, tails map[string]int64) ([]byte, error) {
+	return serialize(data, indexes, tails, nil)
+}
+
+func serialize(data []byte, indexes map[string]string, tails map[string]int64, codec blocks.Codec) ([]byte, error) {
 	offsets := make(map[string]int64)
 
 	for name, value := range indexes {
@@ -66,7 +87,7 @@ func Serialize(data []byte, indexes map[string]string, tails map[string]int64) (
 
 	buf := bytes.NewBuffer([]byte{})
 
-	_, err := event.push(buf)
+	_, err := event.push(buf, codec)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -83,7 +104,7 @@ func (s *openStream) Write(data []byte, indexes map[string]string) (int, error)
 		return 0, err
 	}
 
-	bytes, err := Serialize(data, indexes, s.tails)
+	bytes, err := serialize(data, indexes, s.tails, s.events)
 	if err != nil {
 		return 0, err
 	}
@@ -143,6 +164,10 @@ func (s *openStream) reader() io.ReaderAt {
 	return s.stream
 }
 
+func (s *openStream) codec() blocks.Codec {
+	return s.events
+}
+
 func (s *openStream) Close() (err error) {
 	if s.Closed() {
 		return
@@ -202,6 +227,16 @@ func (s *openStream) Close() (err error) {
 
 func (s *openStream) init() (e error) {
 	s.initlock.Do(func() {
+		codec, _, err := readHeader(s.stream)
+		if err != nil {
+			e = err
+			return
+		}
+
+		// Events appended to the stream are
+		// compressed the same as its others.
+		s.events = codec
+
 		tails, offset, length, err := populate(s)
 
 		e = err
@@ -218,7 +253,10 @@ func (s *openStream) init() (e error) {
 
 func populate(s *openStream) (tails map[string]int64, offset int64, length int, err error) {
 	tails = make(map[string]int64)
-	offset = HEADER_LENGTH
+
+	if _, offset, err = readHeader(s.stream); err != nil {
+		return
+	}
 
 	_, err = iterate(s, 0, func(event *Event) bool {
 		for index, _ := range event.offsets {
@@ -226,7 +264,7 @@ func populate(s *openStream) (tails map[string]int64, offset int64, length int,
 		}
 
 		// set tail for all event indexes
-		offset += int64(event.length())
+		offset += int64(event.size)
 		length += 1
 
 		return true
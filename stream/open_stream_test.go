@@ -1,12 +1,20 @@
 package stream
 
 import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
 	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/customerio/esdb/blocks"
 )
 
 func createStream() Stream {
@@ -113,6 +121,41 @@ func TestTails(t *testing.T) {
 	}
 }
 
+func TestDeterministicStream(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+
+	var hashes [][]byte
+
+	for i := 0; i < 5; i++ {
+		os.Remove("tmp/deterministic.stream")
+
+		s, err := New("tmp/deterministic.stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j < 100; j++ {
+			s.Write([]byte("abc"), map[string]string{"a": "a", "b": "b", "c": strconv.Itoa(j % 7), "d": "d", "e": strconv.Itoa(j % 3)})
+		}
+
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := ioutil.ReadFile("tmp/deterministic.stream")
+		sum := sha256.Sum256(b)
+		hashes = append(hashes, sum[:])
+	}
+
+	os.Remove("tmp/deterministic.stream")
+
+	for i, hash := range hashes[1:] {
+		if !bytes.Equal(hash, hashes[0]) {
+			t.Errorf("Case %d: stream written with the same events differs: %x, wanted %x", i+1, hash, hashes[0])
+		}
+	}
+}
+
 func TestOpenScan(t *testing.T) {
 	s := createStream()
 
@@ -636,3 +679,71 @@ func TestFailedWrite(t *testing.T) {
 		t.Errorf("Wanted: %v, found: %v", []string{"abc", "cde", "def", "fgh"}, found)
 	}
 }
+
+func TestCompressedStream(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+	os.Remove("tmp/test.stream")
+
+	s, err := NewWithOptions("tmp/test.stream", Options{Codec: blocks.Flate(flate.DefaultCompression)})
+	if err != nil {
+		t.Fatalf("Failed to create compressed stream: %v", err)
+	}
+
+	data := strings.Repeat("abc", 100)
+
+	for i := 0; i < 3; i++ {
+		s.Write([]byte(data), map[string]string{"a": "a"})
+	}
+
+	if s.Offset() >= int64(3*len(data)) {
+		t.Errorf("Expected events to be compressed, stream offset: %d", s.Offset())
+	}
+
+	// Events written after reopening are compressed too.
+	s = reopenStream()
+	s.Write([]byte(data), map[string]string{"a": "a"})
+
+	for _, closed := range []bool{false, true} {
+		if closed {
+			if err = s.Close(); err != nil {
+				t.Fatalf("Failed to close compressed stream: %v", err)
+			}
+
+			s = reopenStream()
+		}
+
+		found := 0
+
+		s.Iterate(0, func(e *Event) bool {
+			if string(e.Data) == data {
+				found += 1
+			}
+			return true
+		})
+
+		if found != 4 {
+			t.Errorf("Closed %v: wanted 4 events iterated, found: %d", closed, found)
+		}
+
+		found = 0
+
+		s.ScanIndex("a", "a", 0, func(e *Event) bool {
+			if string(e.Data) == data {
+				found += 1
+			}
+			return true
+		})
+
+		if found != 4 {
+			t.Errorf("Closed %v: wanted 4 events scanned, found: %d", closed, found)
+		}
+	}
+}
+
+func TestUnknownCodecStream(t *testing.T) {
+	rws := &RWS{buf: []byte(MAGIC_COMPRESSED_HEADER + "\x7f")}
+
+	if _, _, err := readHeader(rws); err != UNKNOWN_CODEC {
+		t.Errorf("Wrong error reading header: want: %v got: %v", UNKNOWN_CODEC, err)
+	}
+}
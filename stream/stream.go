@@ -1,20 +1,35 @@
 package stream
 
 import (
+	"errors"
 	"io"
 	"os"
 
 	"github.com/customerio/esdb/binary"
+	"github.com/customerio/esdb/blocks"
 )
 
 const (
 	MAGIC_HEADER = "ESDBstream"
 	MAGIC_FOOTER = "closedESDBstream"
+
+	// Streams with compressed events begin with this header,
+	// followed by a byte for the encoding of their codec, so
+	// they're never mistaken for uncompressed streams.
+	MAGIC_COMPRESSED_HEADER = "ESDBzstream"
 )
 
 var HEADER_LENGTH = int64(len(MAGIC_HEADER))
 var FOOTER_LENGTH = int64(len(MAGIC_FOOTER))
 
+var UNKNOWN_CODEC = errors.New("stream is compressed with an unregistered codec")
+
+type Options struct {
+	// Compresses each event written to the stream, such as with
+	// blocks.Flate. Defaults to storing events uncompressed.
+	Codec blocks.Codec
+}
+
 type Scanner func(*Event) bool
 
 type Streamer interface {
@@ -38,6 +53,7 @@ type Stream interface {
 	Closed() bool
 	Close() error
 	reader() io.ReaderAt
+	codec() blocks.Codec
 }
 
 // Creates a new open stream at the given path. If the
@@ -51,6 +67,24 @@ func New(path string) (Stream, error) {
 	return createOpenStream(file)
 }
 
+// Creates a new open stream at the given path, configured with
+// the given options. If the file already exists, an error will
+// be returned.
+func NewWithOptions(path string, options Options) (Stream, error) {
+	if options.Codec != nil {
+		if _, ok := blocks.LookupCodec(options.Codec.Encoding()); !ok {
+			return nil, UNKNOWN_CODEC
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return createOpenStreamWithOptions(file, options)
+}
+
 func Open(path string) (Stream, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -73,7 +107,7 @@ func Open(path string) (Stream, error) {
 
 func scanIndex(s Stream, index string, offset int64, scanner Scanner) error {
 	for offset > 0 {
-		event, err := pullEvent(s.reader(), offset)
+		event, err := pullEvent(s.reader(), offset, s.codec())
 
 		if err == nil {
 			offset = event.offsets[index]
@@ -91,22 +125,21 @@ func scanIndex(s Stream, index string, offset int64, scanner Scanner) error {
 
 func iterate(s Stream, offset int64, scanner Scanner) (int64, error) {
 	if offset <= 0 {
-		header := binary.ReadBytesAt(s.reader(), HEADER_LENGTH, 0)
-
-		if string(header) != string(MAGIC_HEADER) {
-			return 0, CORRUPTED_HEADER
+		_, length, err := readHeader(s.reader())
+		if err != nil {
+			return 0, err
 		}
 
-		offset = HEADER_LENGTH
+		offset = length
 	}
 
 	var err error
 
 	for err == nil {
-		event, e := pullEvent(s.reader(), offset)
+		event, e := pullEvent(s.reader(), offset, s.codec())
 
 		if e == nil {
-			offset += int64(event.length())
+			offset += int64(event.size)
 
 			if !scanner(event) {
 				err = io.EOF
@@ -122,3 +155,34 @@ func iterate(s Stream, offset int64, scanner Scanner) (int64, error) {
 		return offset, err
 	}
 }
+
+// Reads the header of a stream, returning the codec its events are
+// compressed with, or nil if they aren't, and the header's length.
+func readHeader(r io.ReaderAt) (blocks.Codec, int64, error) {
+	if header := binary.ReadBytesAt(r, HEADER_LENGTH, 0); string(header) == MAGIC_HEADER {
+		return nil, HEADER_LENGTH, nil
+	}
+
+	length := int64(len(MAGIC_COMPRESSED_HEADER))
+
+	header := binary.ReadBytesAt(r, length+1, 0)
+	if int64(len(header)) != length+1 || string(header[:length]) != MAGIC_COMPRESSED_HEADER {
+		return nil, 0, CORRUPTED_HEADER
+	}
+
+	codec, ok := blocks.LookupCodec(int(header[length]))
+	if !ok {
+		return nil, 0, UNKNOWN_CODEC
+	}
+
+	return codec, length + 1, nil
+}
+
+// Writes the header of a new stream with events compressed by the codec.
+func writeHeader(w io.WriterAt, codec blocks.Codec) (int, error) {
+	if codec == nil {
+		return w.WriteAt([]byte(MAGIC_HEADER), 0)
+	}
+
+	return w.WriteAt(append([]byte(MAGIC_COMPRESSED_HEADER), byte(codec.Encoding())), 0)
+}
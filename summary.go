@@ -0,0 +1,94 @@
+package esdb
+
+import (
+	"bytes"
+	"io"
+	"sort"
+
+	"github.com/customerio/esdb/binary"
+)
+
+// A summary records, for every block of a grouping or index section,
+// where the first entry beginning in that block is located, the
+// timestamp of that entry, and how many entries follow it before
+// the next block's first entry.
+//
+// As sections are sorted by timestamp descending, this allows
+// jumping straight to the block containing a given timestamp
+// without decompressing any of the blocks before it.
+type summary []summaryEntry
+
+type summaryEntry struct {
+	block     int64
+	offset    int
+	timestamp int
+	count     int
+}
+
+// Records an entry located at the given block and in-block offset.
+// A new summary entry is only started when the entry is the first
+// one beginning in a block.
+func (s *summary) add(block int64, offset int, timestamp int) {
+	if n := len(*s); n > 0 && (*s)[n-1].block == block {
+		(*s)[n-1].count += 1
+		return
+	}
+
+	*s = append(*s, summaryEntry{block, offset, timestamp, 1})
+}
+
+// Summaries are encoded in the following byte format:
+// [Uvarint:entries]([Uvarint:block][Uvarint:offset][Varint:timestamp][Uvarint:count])...
+func (s summary) write(out io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+
+	binary.WriteUvarint(buf, len(s))
+
+	for _, entry := range s {
+		binary.WriteUvarint64(buf, entry.block)
+		binary.WriteUvarint(buf, entry.offset)
+		binary.WriteVarint(buf, int64(entry.timestamp))
+		binary.WriteUvarint(buf, entry.count)
+	}
+
+	return buf.WriteTo(out)
+}
+
+func readSummary(b []byte) summary {
+	r := bytes.NewReader(b)
+
+	n := int(binary.ReadUvarint(r))
+	s := make(summary, 0, n)
+
+	for i := 0; i < n; i++ {
+		s = append(s, summaryEntry{
+			block:     binary.ReadUvarint(r),
+			offset:    int(binary.ReadUvarint(r)),
+			timestamp: int(binary.ReadVarint(r)),
+			count:     int(binary.ReadUvarint(r)),
+		})
+	}
+
+	return s
+}
+
+// Returns the entry from which to start reading in order to
+// find the newest entry with a timestamp at or before the given
+// timestamp. Entries preceding it in the section are all newer.
+func (s summary) seek(timestamp int) *summaryEntry {
+	if len(s) == 0 {
+		return nil
+	}
+
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].timestamp <= timestamp
+	})
+
+	// The block before the first one starting at or before the timestamp
+	// may still end with entries matching it.
+	if i > 0 {
+		i -= 1
+	}
+
+	return &s[i]
+}
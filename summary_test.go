@@ -0,0 +1,66 @@
+package esdb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSummaryEncoding(t *testing.T) {
+	s := summary{}
+
+	s.add(0, 0, 9)
+	s.add(0, 10, 8)
+	s.add(120, 5, 7)
+	s.add(240, 0, -3)
+	s.add(240, 20, -4)
+
+	want := summary{
+		{0, 0, 9, 2},
+		{120, 5, 7, 1},
+		{240, 0, -3, 2},
+	}
+
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Wrong summary: wanted: %v, found: %v", want, s)
+	}
+
+	buf := new(bytes.Buffer)
+	s.write(buf)
+
+	if found := readSummary(buf.Bytes()); !reflect.DeepEqual(found, want) {
+		t.Errorf("Wrong decoded summary: wanted: %v, found: %v", want, found)
+	}
+}
+
+func TestSummarySeek(t *testing.T) {
+	s := summary{
+		{0, 0, 9, 2},
+		{120, 5, 7, 1},
+		{240, 0, -3, 2},
+	}
+
+	var tests = []struct {
+		timestamp int
+		block     int64
+	}{
+		{20, 0},
+		{9, 0},
+		{8, 0},
+		{7, 0},
+		{6, 120},
+		{-3, 120},
+		{-4, 240},
+		{-100, 240},
+	}
+
+	for i, test := range tests {
+		if entry := s.seek(test.timestamp); entry.block != test.block {
+			t.Errorf("Case #%v: wanted: %d, found: %d", i, test.block, entry.block)
+		}
+	}
+
+	if entry := (summary{}).seek(0); entry != nil {
+		t.Errorf("Expected no entry from an empty summary, found: %v", entry)
+	}
+}
@@ -0,0 +1,417 @@
+package esdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/customerio/esdb/binary"
+	"github.com/customerio/esdb/blocks"
+	"github.com/customerio/esdb/sst"
+)
+
+// VerifyReport describes the result of checking the integrity
+// of an ESDB file with Verify.
+type VerifyReport struct {
+	Spaces   int
+	Sections int
+	Events   int
+	Problems []Problem
+}
+
+// A Problem is an inconsistency found within an ESDB file.
+type Problem struct {
+	// The id of the space containing the problem. Empty
+	// for problems with the file's footer or spaces index.
+	Space []byte
+
+	// The part of the file or space containing the problem,
+	// such as "footer", "header", or `grouping "name"`.
+	Section string
+
+	// The byte offset of the problem within the file.
+	Offset int64
+
+	Message string
+}
+
+func (p Problem) String() string {
+	if len(p.Space) > 0 {
+		return fmt.Sprintf("space %q: %s at offset %d: %s", p.Space, p.Section, p.Offset, p.Message)
+	}
+
+	return fmt.Sprintf("%s at offset %d: %s", p.Section, p.Offset, p.Message)
+}
+
+// Returns true if no problems were found.
+func (r *VerifyReport) Ok() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) problem(space []byte, section string, offset int64, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{space, section, offset, fmt.Sprintf(format, args...)})
+}
+
+// Checks the integrity of an ESDB file. Walks the file's footer, the
+// index of spaces, and for each space its header, index of groupings
+// and indexes, and every block and event within them. Checks offsets
+// and lengths agree, events are ordered, and index entries point at
+// real events.
+//
+// An error is only returned if the file couldn't be read at all.
+// Problems with the file's contents are listed in the report.
+func Verify(path string) (*VerifyReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return verify(file, stat.Size()), nil
+}
+
+type spaceLocation struct {
+	id     []byte
+	offset int64
+	length int64
+}
+
+func verify(r io.ReaderAt, size int64) *VerifyReport {
+	report := &VerifyReport{}
+
+	header, err := readFileHeader(r, size)
+	if err != nil {
+		report.problem(nil, "header", 0, "%v", err)
+		return report
+	}
+
+	indexOffset, indexLen, err := header.locateIndex(r, size)
+	if err != nil {
+		report.problem(nil, "footer", size-header.footerLen(), "%v", err)
+		return report
+	}
+
+	index, err := sst.NewReader(io.NewSectionReader(r, indexOffset, indexLen), indexLen)
+	if err != nil {
+		report.problem(nil, "spaces index", indexOffset, "%v", err)
+		return report
+	}
+
+	iter, err := index.Find([]byte(""))
+	if err != nil {
+		report.problem(nil, "spaces index", indexOffset, "%v", err)
+		return report
+	}
+
+	spaces := make([]spaceLocation, 0)
+
+	for iter.Next() {
+		val := bytes.NewReader(iter.Value())
+
+		// The entry in the SSTable index is
+		// the offset and length of the space
+		// within the file.
+		space := spaceLocation{
+			id:     append([]byte{}, iter.Key()...),
+			offset: binary.ReadInt64(val),
+			length: binary.ReadInt64(val),
+		}
+
+		if val.Len() != 0 {
+			report.problem(space.id, "spaces index", indexOffset, "unexpected %d trailing bytes in index entry", val.Len())
+		}
+
+		if space.offset < 0 || space.length <= 8 || space.offset+space.length > indexOffset {
+			report.problem(space.id, "spaces index", indexOffset, "space location %d+%d is outside of the file's data", space.offset, space.length)
+			continue
+		}
+
+		spaces = append(spaces, space)
+	}
+
+	if err := iter.Close(); err != nil {
+		report.problem(nil, "spaces index", indexOffset, "%v", err)
+	}
+
+	// Spaces are written back to back following the file's
+	// header, so between them they should cover all of the
+	// file's data.
+	sort.Slice(spaces, func(i, j int) bool { return spaces[i].offset < spaces[j].offset })
+
+	expected := header.dataOffset()
+
+	for _, space := range spaces {
+		if space.offset != expected {
+			report.problem(space.id, "spaces index", space.offset, "space begins at %d, but the previous space ends at %d", space.offset, expected)
+		}
+
+		expected = space.offset + space.length
+	}
+
+	if expected != indexOffset {
+		report.problem(nil, "spaces index", expected, "spaces end at %d, but the spaces index begins at %d", expected, indexOffset)
+	}
+
+	for _, space := range spaces {
+		report.Spaces += 1
+		verifySpace(report, r, header, space)
+	}
+
+	return report
+}
+
+type eventLocation struct {
+	block  int64
+	offset int64
+}
+
+func verifySpace(report *VerifyReport, r io.ReaderAt, header fileHeader, location spaceLocation) {
+	id := location.id
+
+	// Magic character marking this as
+	// the start of a space section.
+	if header := binary.ReadBytesAt(r, 1, location.offset); len(header) != 1 || header[0] != 42 {
+		report.problem(id, "header", location.offset, "missing space header")
+	}
+
+	footerOffset := location.offset + location.length - 8
+	indexLen := binary.ReadInt64At(r, footerOffset)
+	indexOffset := footerOffset - indexLen
+
+	if indexLen <= 0 || indexOffset < location.offset+1 {
+		report.problem(id, "footer", footerOffset, "invalid space index length %d", indexLen)
+		return
+	}
+
+	index, err := sst.NewReader(io.NewSectionReader(r, indexOffset, indexLen), indexLen)
+	if err != nil {
+		report.problem(id, "space index", indexOffset, "%v", err)
+		return
+	}
+
+	iter, err := index.Find([]byte(""))
+	if err != nil {
+		report.problem(id, "space index", indexOffset, "%v", err)
+		return
+	}
+
+	space := &Space{Id: id, reader: r, header: header, offset: location.offset, length: location.length, index: index}
+
+	// Locations and timestamps of every event in the space's
+	// groupings, which every index entry should point at.
+	events := make(map[eventLocation]int)
+
+	// Sections are written back to back after
+	// the header, in the order of their keys.
+	expected := int64(1)
+
+	for iter.Next() {
+		key := string(iter.Key())
+		sec := decodeSection(iter.Value())
+
+		name := describeSection(key)
+		offset := location.offset + sec.offset
+
+		if sec.offset != expected {
+			report.problem(id, name, offset, "section begins at %d, but the previous section ends at %d", sec.offset, expected)
+		}
+
+		expected = sec.offset + sec.length + sec.summaryLen
+
+		if sec.offset < 1 || sec.length <= 0 || sec.summaryLen < 0 || location.offset+expected > indexOffset {
+			report.problem(id, name, offset, "section location %d+%d+%d is outside of the space's data", sec.offset, sec.length, sec.summaryLen)
+			continue
+		}
+
+		report.Sections += 1
+
+		if strings.HasPrefix(key, "g") {
+			verifyGrouping(report, space, name, sec, events)
+		} else if strings.HasPrefix(key, "i") {
+			verifyIndex(report, space, name, sec, events)
+		} else {
+			report.problem(id, "space index", indexOffset, "unknown section key %q", key)
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		report.problem(id, "space index", indexOffset, "%v", err)
+	}
+
+	if location.offset+expected != indexOffset {
+		report.problem(id, "space index", location.offset+expected, "sections end at %d, but the space index begins at %d", location.offset+expected, indexOffset)
+	}
+}
+
+func describeSection(key string) string {
+	if strings.HasPrefix(key, "g") {
+		return fmt.Sprintf("grouping %q", key[1:])
+	} else if strings.HasPrefix(key, "i") {
+		return fmt.Sprintf("index %q", key[1:])
+	}
+
+	return fmt.Sprintf("section %q", key)
+}
+
+// Returns a block reader bounded to the section's blocks,
+// so reading past the end of the section is an error.
+func sectionBlocks(space *Space, sec *section) *blocks.Reader {
+	return blocks.NewReader(space.sectionReader(sec.offset, sec.length), space.header.blockSize)
+}
+
+// Returns the location of the reader's next entry within the space,
+// in the same form as the writer recorded it.
+func entryLocation(r *blocks.Reader, sec *section) eventLocation {
+	block, offset := r.Position()
+	return eventLocation{sec.offset + block, int64(offset)}
+}
+
+func verifyGrouping(report *VerifyReport, space *Space, name string, sec *section, events map[eventLocation]int) {
+	r := sectionBlocks(space, sec)
+	found := make(summary, 0)
+	stats := &SectionStats{Bytes: sec.length}
+	previous := math.MaxInt64
+
+	for {
+		location := entryLocation(r, sec)
+
+		event, err := pullEvent(r, space.header)
+		if err != nil {
+			report.problem(space.Id, name, space.offset+location.block, "unreadable event: %v", err)
+			return
+		}
+
+		if event == nil {
+			break
+		}
+
+		if event.Timestamp > previous {
+			report.problem(space.Id, name, space.offset+location.block, "event with timestamp %d follows newer timestamp %d", event.Timestamp, previous)
+		}
+
+		previous = event.Timestamp
+		events[location] = event.Timestamp
+		found.add(location.block, int(location.offset), event.Timestamp)
+		stats.add(event.Timestamp)
+		report.Events += 1
+	}
+
+	if extra := r.Peek(1); len(extra) > 0 {
+		report.problem(space.Id, name, space.offset+entryLocation(r, sec).block, "unexpected data after the end of the grouping")
+	}
+
+	verifySummary(report, space, name, sec, found)
+	verifyStats(report, space, name, sec, stats)
+}
+
+func verifyIndex(report *VerifyReport, space *Space, name string, sec *section, events map[eventLocation]int) {
+	r := sectionBlocks(space, sec)
+	found := make(summary, 0)
+	stats := &SectionStats{Bytes: sec.length}
+	previous := math.MaxInt64
+
+	for {
+		location := entryLocation(r, sec)
+
+		entry, err := pullIndexEntry(r, space.header)
+		if err == io.ErrUnexpectedEOF {
+			report.problem(space.Id, name, space.offset+location.block, "index isn't terminated")
+			return
+		} else if err != nil {
+			report.problem(space.Id, name, space.offset+location.block, "unreadable index entry: %v", err)
+			return
+		}
+
+		// Skip over the terminator marking the end of the index.
+		if entry == nil {
+			if space.header.flags&FLAG_INDEX_TERMINATORS != 0 {
+				binary.ReadFull(r, int64(space.header.indexEntryLen()))
+			} else {
+				r.ReadByte()
+			}
+
+			break
+		}
+
+		target := eventLocation{entry.block, entry.offset}
+
+		timestamp, ok := events[target]
+		if !ok {
+			report.problem(space.Id, name, space.offset+location.block, "index entry points at %d+%d, which isn't an event", target.block, target.offset)
+			continue
+		}
+
+		if space.header.indexTimestamps() && entry.timestamp != timestamp {
+			report.problem(space.Id, name, space.offset+location.block, "index entry has timestamp %d, but its event has timestamp %d", entry.timestamp, timestamp)
+		}
+
+		if timestamp > previous {
+			report.problem(space.Id, name, space.offset+location.block, "index entry with timestamp %d follows newer timestamp %d", timestamp, previous)
+		}
+
+		previous = timestamp
+		found.add(location.block, int(location.offset), timestamp)
+		stats.add(timestamp)
+	}
+
+	if extra := r.Peek(1); len(extra) > 0 {
+		report.problem(space.Id, name, space.offset+entryLocation(r, sec).block, "unexpected data after the end of the index")
+	}
+
+	verifySummary(report, space, name, sec, found)
+	verifyStats(report, space, name, sec, stats)
+}
+
+// Checks the section's stored summary agrees with its entries.
+func verifySummary(report *VerifyReport, space *Space, name string, sec *section, found summary) {
+	if sec.summaryLen == 0 {
+		return
+	}
+
+	offset := space.offset + sec.offset + sec.length
+
+	stored, err := space.readSummary(sec)
+	if err != nil {
+		report.problem(space.Id, name, offset, "unreadable summary: %v", err)
+		return
+	}
+
+	if len(stored) != len(found) {
+		report.problem(space.Id, name, offset, "summary has %d blocks, but the section has %d", len(stored), len(found))
+		return
+	}
+
+	for i := range stored {
+		if stored[i] != found[i] {
+			report.problem(space.Id, name, offset, "summary entry %d is %v, but the section has %v", i, stored[i], found[i])
+		}
+	}
+}
+
+// Checks the section's stored stats agree with its entries.
+func verifyStats(report *VerifyReport, space *Space, name string, sec *section, found *SectionStats) {
+	if sec.stats == nil {
+		return
+	}
+
+	blockCount, err := space.countBlocks(sec)
+	if err != nil {
+		report.problem(space.Id, name, space.offset+sec.offset, "unreadable block headers: %v", err)
+		return
+	}
+
+	found.Blocks = blockCount
+
+	if *sec.stats != *found {
+		report.problem(space.Id, name, space.offset+sec.offset, "stats are %+v, but the section has %+v", *sec.stats, *found)
+	}
+}
@@ -0,0 +1,159 @@
+package esdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/customerio/esdb/binary"
+)
+
+func createVerifyDb(t *testing.T) []byte {
+	os.MkdirAll("tmp", 0755)
+	os.Remove("tmp/verify.esdb")
+
+	w, err := New("tmp/verify.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 300; i++ {
+		data := []byte(strconv.Itoa(i) + strings.Repeat("-", 100))
+		w.Add([]byte(strconv.Itoa(i%3)), data, i, strconv.Itoa(i%2), map[string]string{"type": strconv.Itoa(i % 5)})
+	}
+
+	if err = w.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile("tmp/verify.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestVerify(t *testing.T) {
+	createVerifyDb(t)
+
+	report, err := Verify("tmp/verify.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Ok() {
+		t.Errorf("Unexpected problems: %v", report.Problems)
+	}
+
+	if report.Spaces != 3 || report.Sections != 21 || report.Events != 300 {
+		t.Errorf("Wrong counts: wanted: 3,21,300 found: %d,%d,%d", report.Spaces, report.Sections, report.Events)
+	}
+
+	if _, err = Verify("tmp/missing.esdb"); err == nil {
+		t.Errorf("Expected error verifying a missing file")
+	}
+}
+
+func TestVerifyCorruption(t *testing.T) {
+	original := createVerifyDb(t)
+
+	db, _ := Open("tmp/verify.esdb")
+	space := db.Find([]byte("1"))
+	grouping, _ := space.findSection("g1")
+	index, _ := space.findSection("itype:3")
+	db.Close()
+
+	var tests = []struct {
+		offset  int64
+		section string
+	}{
+		// The file's magic number.
+		{0, "header"},
+		// The last byte of the footer.
+		{int64(len(original) - 1), "footer"},
+		// The spaces index length in the footer.
+		{int64(len(original) - footerLen + 7), "footer"},
+		// The space's magic header byte.
+		{space.offset, "header"},
+		// The first event of a grouping.
+		{space.offset + grouping.offset + 8, `grouping "1"`},
+		// The first entry of an index.
+		{space.offset + index.offset + 4, `index "type:3"`},
+		// The grouping's summary.
+		{space.offset + grouping.offset + grouping.length + 1, `grouping "1"`},
+	}
+
+	for i, test := range tests {
+		corrupted := append([]byte{}, original...)
+		corrupted[test.offset] ^= 0xff
+
+		report := verify(bytes.NewReader(corrupted), int64(len(corrupted)))
+
+		if report.Ok() {
+			t.Errorf("Case #%d: expected problems after corrupting offset %d", i, test.offset)
+			continue
+		}
+
+		if problem := report.Problems[0]; problem.Section != test.section {
+			t.Errorf("Case #%d: wrong problem section: wanted: %s found: %v", i, test.section, problem)
+		}
+	}
+}
+
+func TestVerifyStats(t *testing.T) {
+	original := createVerifyDb(t)
+
+	db, _ := Open("tmp/verify.esdb")
+	defer db.Close()
+
+	space := db.Find([]byte("1"))
+
+	footerOffset := space.offset + space.length - 8
+	indexLen := binary.ReadInt64At(bytes.NewReader(original), footerOffset)
+
+	// Rewrite the space's index with the wrong
+	// number of events in a grouping's stats.
+	buf := new(bytes.Buffer)
+	st := newIndexWriter(buf)
+
+	iter, _ := space.index.Find([]byte(""))
+	for iter.Next() {
+		val := iter.Value()
+
+		if string(iter.Key()) == "g1" {
+			sec := decodeSection(val)
+			b := new(bytes.Buffer)
+
+			binary.WriteUvarint64(b, sec.offset)
+			binary.WriteUvarint64(b, sec.length)
+			binary.WriteUvarint64(b, sec.summaryLen)
+			binary.WriteUvarint(b, sec.stats.Events+1)
+			binary.WriteVarint(b, int64(sec.stats.MinTimestamp))
+			binary.WriteVarint(b, int64(sec.stats.MaxTimestamp))
+			binary.WriteUvarint(b, sec.stats.Blocks)
+
+			val = b.Bytes()
+		}
+
+		st.Set(append([]byte{}, iter.Key()...), append([]byte{}, val...))
+	}
+
+	st.Close()
+
+	if int64(buf.Len()) != indexLen {
+		t.Fatalf("Rewritten space index is %d bytes, wanted %d bytes", buf.Len(), indexLen)
+	}
+
+	corrupted := append([]byte{}, original...)
+	copy(corrupted[footerOffset-indexLen:], buf.Bytes())
+
+	report := verify(bytes.NewReader(corrupted), int64(len(corrupted)))
+
+	if len(report.Problems) != 1 || report.Problems[0].Section != `grouping "1"` || !strings.Contains(report.Problems[0].Message, "stats") {
+		t.Errorf("Expected a problem with the grouping's stats: %v", report.Problems)
+	}
+}
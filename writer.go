@@ -1,18 +1,32 @@
 package esdb
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
+	"time"
 
 	"github.com/customerio/esdb/binary"
+	"github.com/customerio/esdb/blocks"
 	"github.com/customerio/esdb/sst"
 )
 
 // Writer provides an interface for creating a new ESDB file.
 type Writer struct {
+	writer       io.Writer
 	file         *os.File
+	path         string
+	replace      bool
+	header       fileHeader
+	options      WriterOptions
+	sorter       *recordSorter
+	seq          int64
 	spaces       map[string]*spaceWriter
 	spaceIds     sort.StringSlice
 	offset       int64
@@ -21,16 +35,148 @@ type Writer struct {
 	written      bool
 }
 
+// WriterOptions configures how a new ESDB file is written.
+type WriterOptions struct {
+	// The unit of the timestamps passed to Add, which is recorded
+	// in the file, and used by Event.Time. Defaults to Seconds.
+	Precision Precision
+
+	// The approximate number of bytes of events to hold in memory
+	// before they're sorted and spilled to temporary files, which
+	// are merged when the spaces are written. Indexes are sorted
+	// the same way when each space is written. Zero, the default,
+	// holds all events in memory until they're written.
+	MemoryLimit int64
+
+	// The directory for temporary files. Defaults to os.TempDir.
+	TempDir string
+
+	// Compresses the blocks of the file, such as blocks.Flate for
+	// smaller files. Defaults to blocks.Snappy. Custom codecs must be
+	// registered with blocks.RegisterCodec for the file to be read.
+	Codec blocks.Codec
+
+	// The number of bytes of events, or index entries, compressed
+	// together in each block, which is recorded in the file. Larger
+	// blocks compress better, while smaller blocks read less to
+	// find a single event. Defaults to DEFAULT_BLOCK_SIZE, and
+	// can't be larger than MAX_BLOCK_SIZE.
+	BlockSize int
+
+	// The number of spaces Write encodes and compresses concurrently,
+	// which are written to the file in order, so the file is the same
+	// with any number of workers. Spaces are encoded into buffers in
+	// memory, or with a MemoryLimit, into temporary files in the
+	// TempDir, with the limit shared by the workers' sorts of index
+	// entries. Custom codecs must be safe to use from many goroutines.
+	// Defaults to 1, which writes each space directly to the file as
+	// it's encoded.
+	Workers int
+}
+
 // Creates a new ESDB database at the given path. If the
 // file already exists, an error will be returned.
 func New(path string) (*Writer, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0755)
+	return NewWithOptions(path, WriterOptions{})
+}
+
+// Creates a new ESDB database at the given path, configured
+// with the given options. If the file already exists, an error
+// will be returned.
+//
+// The file is written to a temporary file in the same directory,
+// which is only linked to path once Write has written and synced
+// all of it, so a partly written file is never found at path. If
+// another file has been created at path since, Write returns an
+// error rather than replacing it. If Write fails, or the Writer is
+// aborted, the temporary file is removed.
+func NewWithOptions(path string, options WriterOptions) (*Writer, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		return nil, &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return createFile(path, options, 0644, false)
+}
+
+// Creates a writer of a temporary file next to path, with the given
+// mode, which is moved to path once it's been written, replacing any
+// file already at path if replace is true.
+func createFile(path string, options WriterOptions, mode os.FileMode, replace bool) (*Writer, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
 	if err != nil {
 		return nil, err
 	}
 
+	// Temporary files are only readable by their owner.
+	err = file.Chmod(mode)
+
+	var w *Writer
+	if err == nil {
+		w, err = NewWriterWithOptions(file, options)
+	}
+
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	w.file = file
+	w.path = path
+	w.replace = replace
+
+	return w, nil
+}
+
+// Creates a new ESDB database written to out, such as a
+// bytes.Buffer, or an upload of the file. The file is written
+// sequentially, and out isn't closed once it's been written.
+func NewWriter(out io.Writer) (*Writer, error) {
+	return NewWriterWithOptions(out, WriterOptions{})
+}
+
+// Creates a new ESDB database written to out,
+// configured with the given options.
+func NewWriterWithOptions(out io.Writer, options WriterOptions) (*Writer, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	header := currentHeader()
+	header.precision = options.Precision
+
+	if options.Codec != nil {
+		header.codec = options.Codec
+	}
+
+	if options.BlockSize > 0 {
+		header.blockSize = options.BlockSize
+	}
+
+	// The file header describes the format
+	// the rest of the file is written in.
+	offset, err := header.write(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var sorter *recordSorter
+	if options.MemoryLimit > 0 {
+		sorter = newRecordSorter(options.MemoryLimit, options.TempDir)
+	}
+
 	return &Writer{
-		file:         file,
+		writer:       out,
+		header:       header,
+		options:      options,
+		sorter:       sorter,
+		offset:       offset,
 		spaces:       make(map[string]*spaceWriter),
 		spaceIds:     make(sort.StringSlice, 0),
 		spaceOffsets: make(map[string]int64),
@@ -38,29 +184,88 @@ func New(path string) (*Writer, error) {
 	}, nil
 }
 
+func (options WriterOptions) validate() error {
+	if !options.Precision.valid() {
+		return fmt.Errorf("esdb: invalid timestamp precision %d", options.Precision)
+	}
+
+	if options.Codec != nil {
+		if _, ok := blocks.LookupCodec(options.Codec.Encoding()); !ok {
+			return fmt.Errorf("esdb: codec with encoding %d isn't registered", options.Codec.Encoding())
+		}
+	}
+
+	if options.BlockSize < 0 || options.BlockSize > MAX_BLOCK_SIZE {
+		return fmt.Errorf("esdb: invalid block size %d", options.BlockSize)
+	}
+
+	if options.Workers < 0 {
+		return fmt.Errorf("esdb: invalid number of workers %d", options.Workers)
+	}
+
+	return nil
+}
+
 // Adds a new event to the specified space, with grouping and indexes. Events aren't
 // written to the file until writer.Flush(spaceId) or writer.Write() is called.
+//
+// The timestamp is in units of the writer's precision, seconds by default.
 func (w *Writer) Add(spaceId []byte, data []byte, timestamp int, grouping string, indexes map[string]string) error {
 	if w.written {
 		return errors.New("Cannot add to database. We're immutable and this one has already been written.")
 	}
 
+	if w.sorter != nil {
+		return w.addRecord(spaceId, data, timestamp, grouping, indexes)
+	}
+
 	space := w.spaces[string(spaceId)]
 	event := newEvent(data, timestamp)
 
 	if space == nil {
-		space = newSpace(w.file, spaceId)
+		space = newSpace(w.writer, w.header, spaceId)
 		w.spaces[string(spaceId)] = space
 	}
 
 	return space.add(event, grouping, indexes)
 }
 
+// Holds the event to be sorted with the other events of the space,
+// along with the indexes it's in, which are only added once the
+// event has been written.
+func (w *Writer) addRecord(spaceId []byte, data []byte, timestamp int, grouping string, indexes map[string]string) error {
+	rec := &record{
+		section:   "g" + grouping,
+		timestamp: timestamp,
+		seq:       w.seq,
+		data:      data,
+		indexes:   make([]string, 0, len(indexes)),
+	}
+
+	for name, val := range indexes {
+		rec.indexes = append(rec.indexes, "i"+name+":"+val)
+	}
+
+	w.seq += 1
+
+	return w.sorter.add(string(spaceId), rec)
+}
+
+// Like Add, but with the event's time converted to
+// a timestamp with the writer's precision.
+func (w *Writer) AddTime(spaceId []byte, data []byte, t time.Time, grouping string, indexes map[string]string) error {
+	return w.Add(spaceId, data, w.header.precision.Timestamp(t), grouping, indexes)
+}
+
 // Flush writes an individual space to the file. This prevents any additional events from being
 // added to the space. It may be advantagous to flush spaces individually once you've added
 // events for that space, as flushing will reduce use the memory usage of creating a new ESDB file.
 func (w *Writer) Flush(spaceId []byte) (err error) {
-	if space := w.spaces[string(spaceId)]; space != nil {
+	if w.sorter != nil {
+		if w.sorter.has(string(spaceId)) {
+			err = w.writeSortedSpace(string(spaceId))
+		}
+	} else if space := w.spaces[string(spaceId)]; space != nil {
 		err = w.writeSpace(space)
 	}
 
@@ -68,43 +273,343 @@ func (w *Writer) Flush(spaceId []byte) (err error) {
 }
 
 // Write flushes any remaining spaces to the file, writes the index
-// for locating spaces, and closes the file.
+// for locating spaces, and closes the file, if the Writer created it,
+// moving it to its path. If the file can't be written, the Writer is
+// aborted.
+//
+// Spaces are written ordered by id, so the same events always
+// produce the same file, with or without a MemoryLimit.
 func (w *Writer) Write() (err error) {
-	for _, space := range w.spaces {
-		if err = w.writeSpace(space); err != nil {
-			return
+	defer func() {
+		if err != nil {
+			w.Abort()
+		}
+	}()
+
+	if w.sorter != nil {
+		defer w.sorter.close()
+	}
+
+	if w.options.Workers > 1 {
+		err = w.writeConcurrently()
+	} else if w.sorter != nil {
+		for _, id := range w.sorter.spaces() {
+			if err = w.writeSortedSpace(id); err != nil {
+				return
+			}
+		}
+	} else {
+		for _, id := range w.spaceOrder() {
+			if err = w.writeSpace(w.spaces[id]); err != nil {
+				return
+			}
 		}
 	}
 
+	if err != nil {
+		return err
+	}
+
 	length, err := w.writeIndex()
 	if err != nil {
 		return err
 	}
 
-	return w.writeFooter(length)
+	if err = w.writeFooter(length); err != nil {
+		return err
+	}
+
+	return w.commit()
+}
+
+// Abort discards the events which haven't been written, and removes
+// any temporary files, including the partly written file of a Writer
+// created with a path, which is never moved to its path. Nothing
+// more can be added to the Writer. Data already written to the
+// io.Writer of a Writer created with NewWriter is left as it is.
+//
+// Aborting a Writer which has been written does nothing.
+func (w *Writer) Abort() error {
+	w.written = true
+	w.spaces = make(map[string]*spaceWriter)
+
+	var err error
+
+	if w.sorter != nil {
+		err = w.sorter.close()
+	}
+
+	if w.file != nil {
+		w.file.Close()
+
+		if e := os.Remove(w.file.Name()); err == nil {
+			err = e
+		}
+
+		w.file = nil
+	}
+
+	return err
+}
+
+// Syncs and closes the file the Writer created, if any, and moves it
+// to its path, syncing the directory so the move is durable.
+func (w *Writer) commit() error {
+	if w.file == nil {
+		return nil
+	}
+
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	tmp := w.file.Name()
+
+	if w.replace {
+		if err := os.Rename(tmp, w.path); err != nil {
+			return err
+		}
+	} else {
+		// Unlike renaming, linking fails if a file has been
+		// created at the path since the Writer was created.
+		if err := os.Link(tmp, w.path); err != nil {
+			if os.IsExist(err) {
+				return &os.PathError{Op: "create", Path: w.path, Err: os.ErrExist}
+			}
+
+			return err
+		}
+	}
+
+	w.file = nil
+
+	var err error
+	if !w.replace {
+		err = os.Remove(tmp)
+	}
+
+	if e := syncDir(filepath.Dir(w.path)); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+
+	if e := d.Close(); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+// Returns the ids of the spaces held in memory, sorted.
+func (w *Writer) spaceOrder() []string {
+	ids := make(sort.StringSlice, 0, len(w.spaces))
+	for id := range w.spaces {
+		ids = append(ids, id)
+	}
+
+	ids.Sort()
+
+	return ids
+}
+
+// A space being encoded by one of the workers, into a buffer, or
+// with a MemoryLimit, into a segment in a temporary file.
+type encodedSpace struct {
+	id      string
+	buf     bytes.Buffer
+	segment *os.File
+	length  int64
+	err     error
+	done    chan struct{}
+}
+
+// Returns where the space is encoded to.
+func (s *encodedSpace) out() io.Writer {
+	if s.segment != nil {
+		return s.segment
+	}
+
+	return &s.buf
+}
+
+// Copies the encoded space to out.
+func (s *encodedSpace) writeTo(out io.Writer) error {
+	if s.segment == nil {
+		_, err := s.buf.WriteTo(out)
+		return err
+	}
+
+	_, err := io.Copy(out, io.NewSectionReader(s.segment, 0, s.length))
+	return err
+}
+
+// Removes the space's segment, if any.
+func (s *encodedSpace) close() {
+	if s.segment != nil {
+		s.segment.Close()
+		os.Remove(s.segment.Name())
+	}
+}
+
+// Writes the remaining spaces, encoding up to Workers of them at once,
+// while the spaces before them are written. Spaces are taken from the
+// writer in order, and written to the file in the same order, as they
+// would be written one at a time.
+//
+// With a MemoryLimit, spaces are encoded into segments in the TempDir,
+// rather than in memory, so the memory used doesn't grow with the
+// size of the spaces.
+func (w *Writer) writeConcurrently() error {
+	var ids []string
+	var limit int64
+
+	if w.sorter != nil {
+		ids = w.sorter.spaces()
+		limit = w.options.MemoryLimit / int64(w.options.Workers)
+	} else {
+		ids = w.spaceOrder()
+	}
+
+	queue := make([]*encodedSpace, 0, w.options.Workers)
+
+	// Waits for the spaces still being encoded
+	// if a space can't be written.
+	defer func() {
+		for _, space := range queue {
+			<-space.done
+			space.close()
+		}
+	}()
+
+	for len(ids) > 0 || len(queue) > 0 {
+		for len(ids) > 0 && len(queue) < w.options.Workers {
+			space := &encodedSpace{id: ids[0], done: make(chan struct{})}
+
+			if w.sorter != nil {
+				segment, err := ioutil.TempFile(w.options.TempDir, "esdb-space-")
+				if err != nil {
+					return err
+				}
+
+				space.segment = segment
+			}
+
+			queue = append(queue, space)
+			ids = ids[1:]
+
+			// Taking the space's events from the writer isn't safe to
+			// do concurrently, but encoding them is.
+			var encode func() (int64, error)
+
+			if w.sorter != nil {
+				records := w.sorter.take(space.id)
+				encode = func() (int64, error) { return w.encodeRecords(space.out(), records, limit) }
+			} else {
+				sw := w.spaces[space.id]
+				delete(w.spaces, space.id)
+				sw.writer = space.out()
+				encode = sw.write
+			}
+
+			go func() {
+				space.length, space.err = encode()
+				close(space.done)
+			}()
+		}
+
+		space := queue[0]
+		<-space.done
+		queue = queue[1:]
+
+		err := space.err
+		if err == nil {
+			err = space.writeTo(w.writer)
+		}
+
+		space.close()
+
+		if err != nil {
+			return err
+		}
+
+		w.addSpace(space.id, space.length)
+	}
+
+	return nil
 }
 
 func (w *Writer) writeSpace(space *spaceWriter) (err error) {
 	length, err := space.write()
 
 	if err == nil {
-		w.spaceIds = append(w.spaceIds, string(space.Id))
-		w.spaceOffsets[string(space.Id)] = w.offset
-		w.spaceLengths[string(space.Id)] = int64(length)
+		w.addSpace(string(space.Id), length)
 		delete(w.spaces, string(space.Id))
-		w.offset += length
 	}
 
 	return
 }
 
+// Writes a space from its sorted events, which may have
+// been spilled to disk.
+func (w *Writer) writeSortedSpace(id string) error {
+	return w.writeRecords(id, w.sorter.take(id), w.options.MemoryLimit)
+}
+
+// Writes a space from its events, sorted as by a recordSorter,
+// sorting the entries of its indexes with the given memory limit.
+func (w *Writer) writeRecords(id string, records *mergeIterator, limit int64) error {
+	length, err := w.encodeRecords(w.writer, records, limit)
+	if err == nil {
+		w.addSpace(id, length)
+	}
+
+	return err
+}
+
+// Encodes a space from its sorted events to out, returning its length.
+func (w *Writer) encodeRecords(out io.Writer, records *mergeIterator, limit int64) (int64, error) {
+	buf := bufio.NewWriter(out)
+
+	entries := newRecordSorter(limit, w.options.TempDir)
+	defer entries.close()
+
+	length, err := writeSortedSpace(buf, w.header, records, entries)
+	if err == nil {
+		err = buf.Flush()
+	}
+
+	return length, err
+}
+
+// Records the location of a space which has been written.
+func (w *Writer) addSpace(id string, length int64) {
+	w.spaceIds = append(w.spaceIds, id)
+	w.spaceOffsets[id] = w.offset
+	w.spaceLengths[id] = length
+	w.offset += length
+}
+
 // The ESDB index is a SSTable mapping space
 // ids to their offsets in the file.
 func (w *Writer) writeIndex() (int64, error) {
 	w.written = true
 
 	buf := new(bytes.Buffer)
-	st := sst.NewWriter(buf)
+	st := newIndexWriter(buf)
 
 	w.spaceIds.Sort()
 
@@ -126,15 +631,19 @@ func (w *Writer) writeIndex() (int64, error) {
 		return 0, err
 	}
 
-	return buf.WriteTo(w.file)
+	return buf.WriteTo(w.writer)
 }
 
 func (w *Writer) writeFooter(indexLen int64) (err error) {
-	buf := new(bytes.Buffer)
-
-	binary.WriteInt64(buf, indexLen)
-
-	_, err = buf.WriteTo(w.file)
-
+	_, err = writeFileFooter(w.writer, indexLen)
 	return
 }
+
+// Index tables are written with bloom filters of their keys, so
+// finding spaces, groupings and indexes which aren't in the file
+// usually doesn't read any of the table's data blocks.
+func newIndexWriter(w io.Writer) *sst.Writer {
+	return sst.NewWriterWithOptions(w, sst.WriterOptions{
+		Filter: sst.BloomFilter(10),
+	})
+}
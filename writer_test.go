@@ -1,12 +1,19 @@
 package esdb
 
 import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
 	"encoding/csv"
 	"encoding/json"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"reflect"
 	"strconv"
 	"testing"
+
+	"github.com/customerio/esdb/blocks"
 )
 
 type visit struct {
@@ -69,6 +76,425 @@ func TestDisallowOverwrittingExistingDb(t *testing.T) {
 	}
 }
 
+func writeVisits(t *testing.T, path string, visits []visit, options WriterOptions, flush string) []byte {
+	w, err := NewWithOptions(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range visits {
+		err := w.Add([]byte(e.City), e.data, e.Timestamp, e.Host, map[string]string{"visitor": e.Visitor, "type": e.EventType})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if flush != "" {
+		if err := w.Flush([]byte(flush)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	return b
+}
+
+func TestNewWriter(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+
+	var tests = []WriterOptions{
+		{},
+		{MemoryLimit: 64 * 1024, TempDir: "tmp"},
+		{Precision: Milliseconds, Codec: blocks.NoCompression},
+	}
+
+	for i, options := range tests {
+		expected := writeVisits(t, "tmp/path"+strconv.Itoa(i)+".esdb", visits, options, "")
+
+		buf := new(bytes.Buffer)
+
+		w, err := NewWriterWithOptions(buf, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range visits {
+			w.Add([]byte(e.City), e.data, e.Timestamp, e.Host, map[string]string{"visitor": e.Visitor, "type": e.EventType})
+		}
+
+		if err = w.Write(); err != nil {
+			t.Fatal(err)
+		}
+
+		if found := buf.Bytes(); !bytes.Equal(found, expected) {
+			t.Errorf("Case %d: file written to a buffer differs from the file written to a path: %d bytes, wanted %d bytes", i, len(found), len(expected))
+		}
+	}
+
+	if _, err := NewWriterWithOptions(new(bytes.Buffer), WriterOptions{BlockSize: -1}); err == nil {
+		t.Errorf("Expected error creating a writer with an invalid block size")
+	}
+}
+
+func TestWriterMemoryLimit(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+
+	os.MkdirAll("tmp/spill", 0755)
+
+	var tests = []struct {
+		limit int64
+		flush string
+	}{
+		{64 * 1024, ""},
+		{1024 * 1024, ""},
+		{64 * 1024, "Kingsbridge"},
+		{512, ""},
+	}
+
+	for i, test := range tests {
+		expected := writeVisits(t, "tmp/memory"+strconv.Itoa(i)+".esdb", visits, WriterOptions{}, test.flush)
+
+		path := "tmp/spilled" + strconv.Itoa(i) + ".esdb"
+		options := WriterOptions{MemoryLimit: test.limit, TempDir: "tmp/spill"}
+
+		if found := writeVisits(t, path, visits, options, test.flush); !bytes.Equal(found, expected) {
+			t.Errorf("Case %d: spilled file differs from in-memory file: %d bytes, wanted %d bytes", i, len(found), len(expected))
+		}
+
+		if report, err := Verify(path); err != nil || !report.Ok() {
+			t.Errorf("Case %d: spilled file failed verification: %v %v", i, err, report.Problems)
+		}
+
+		if files, _ := ioutil.ReadDir("tmp/spill"); len(files) != 0 {
+			t.Errorf("Case %d: temporary files weren't removed: %d remaining", i, len(files))
+		}
+	}
+}
+
+func TestWriterWorkers(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+
+	os.MkdirAll("tmp/spill", 0755)
+
+	var tests = []struct {
+		options WriterOptions
+		flush   string
+	}{
+		{WriterOptions{Workers: 4}, ""},
+		{WriterOptions{Workers: 2}, "Kingsbridge"},
+		{WriterOptions{Workers: 8, Codec: blocks.Flate(flate.BestSpeed)}, ""},
+		{WriterOptions{Workers: 4, MemoryLimit: 64 * 1024, TempDir: "tmp/spill"}, ""},
+		{WriterOptions{Workers: 3, MemoryLimit: 64 * 1024, TempDir: "tmp/spill"}, "Kingsbridge"},
+	}
+
+	for i, test := range tests {
+		serial := test.options
+		serial.Workers = 0
+
+		expected := writeVisits(t, "tmp/serial"+strconv.Itoa(i)+".esdb", visits, serial, test.flush)
+
+		path := "tmp/workers" + strconv.Itoa(i) + ".esdb"
+
+		if found := writeVisits(t, path, visits, test.options, test.flush); !bytes.Equal(found, expected) {
+			t.Errorf("Case %d: file written by workers differs from serial file: %d bytes, wanted %d bytes", i, len(found), len(expected))
+		}
+
+		if report, err := Verify(path); err != nil || !report.Ok() {
+			t.Errorf("Case %d: file written by workers failed verification: %v %v", i, err, report.Problems)
+		}
+
+		if files, _ := ioutil.ReadDir("tmp/spill"); len(files) != 0 {
+			t.Errorf("Case %d: temporary files weren't removed: %d remaining", i, len(files))
+		}
+	}
+
+	if _, err := NewWithOptions("tmp/invalid.esdb", WriterOptions{Workers: -1}); err == nil {
+		t.Errorf("Expected error for invalid number of workers")
+	}
+}
+
+func TestWriterDeterministic(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+
+	os.MkdirAll("tmp/spill", 0755)
+
+	// Each way of writing the file writes the same bytes.
+	var tests = []WriterOptions{
+		{},
+		{MemoryLimit: 64 * 1024, TempDir: "tmp/spill"},
+		{Workers: 4},
+		{Workers: 4, MemoryLimit: 64 * 1024, TempDir: "tmp/spill"},
+	}
+
+	var expected [sha256.Size]byte
+
+	for i, options := range tests {
+		for j := 0; j < 3; j++ {
+			// Indexes are added from maps, which are iterated
+			// in a different order each time.
+			sum := sha256.Sum256(writeVisits(t, "tmp/deterministic"+strconv.Itoa(i*3+j)+".esdb", visits, options, ""))
+
+			if i == 0 && j == 0 {
+				expected = sum
+			} else if sum != expected {
+				t.Errorf("Case %d: file written with the same events differs: %x, wanted %x", i, sum, expected)
+			}
+		}
+	}
+}
+
+func TestWriterSpills(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+	os.Remove("tmp/spills.esdb")
+
+	w, _ := NewWithOptions("tmp/spills.esdb", WriterOptions{MemoryLimit: 4096, TempDir: "tmp"})
+
+	for i := 0; i < 1000; i++ {
+		w.Add([]byte("a"), []byte(strconv.Itoa(i)), i%10, "", map[string]string{"odd": strconv.Itoa(i % 2)})
+	}
+
+	// Events are spilled to more runs than are merged at once,
+	// so some of the runs will have been merged together.
+	levels := make(map[int]int)
+	for _, run := range w.sorter.runs {
+		levels[run.level] += 1
+	}
+
+	if levels[1] != 1 || levels[0] == 0 || levels[0] >= mergeRuns {
+		t.Errorf("Expected events to be spilled and merged, found runs of levels: %v", levels)
+	}
+
+	if w.sorter.size >= 4096 {
+		t.Errorf("Expected held events to stay within the limit, found: %d bytes", w.sorter.size)
+	}
+
+	w.Write()
+
+	db, _ := Open("tmp/spills.esdb")
+	defer db.Close()
+
+	found := fetchSpaceIndex(db, []byte("a"), "odd", "1")
+
+	if len(found) != 500 || found[0] != "9" || found[1] != "19" || found[499] != "991" {
+		t.Errorf("Wrong events from spilled index: %d events, starting %v", len(found), found[:2])
+	}
+}
+
+func TestWriterCodecs(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+
+	var tests = []struct {
+		codec blocks.Codec
+		size  int
+	}{
+		{blocks.NoCompression, 0},
+		{blocks.Snappy, 0},
+		{blocks.Flate(flate.DefaultCompression), 0},
+	}
+
+	var expected []string
+
+	for i, test := range tests {
+		path := "tmp/codec" + strconv.Itoa(i) + ".esdb"
+		tests[i].size = len(writeVisits(t, path, visits, WriterOptions{Codec: test.codec}, ""))
+
+		if report, err := Verify(path); err != nil || !report.Ok() {
+			t.Errorf("Case %d: file failed verification: %v %v", i, err, report.Problems)
+		}
+
+		db, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := fetchSpaceIndex(db, []byte("Kingsbridge"), "type", "visit")
+		db.Close()
+
+		if expected == nil {
+			expected = found
+		} else if !reflect.DeepEqual(found, expected) {
+			t.Errorf("Case %d: wrong events: wanted %d events, found %d", i, len(expected), len(found))
+		}
+	}
+
+	if len(expected) == 0 {
+		t.Errorf("Expected events to be found")
+	}
+
+	if tests[0].size <= tests[1].size || tests[1].size <= tests[2].size {
+		t.Errorf("Expected files to be smaller with stronger codecs, found sizes: %d %d %d", tests[0].size, tests[1].size, tests[2].size)
+	}
+}
+
+func TestWriterBlockSizes(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
+
+	var expected []string
+
+	// Blocks of 64KiB and above have wider index entries.
+	for i, size := range []int{256, DEFAULT_BLOCK_SIZE, 65535, 65536, 1 << 20} {
+		path := "tmp/blocksize" + strconv.Itoa(i) + ".esdb"
+		writeVisits(t, path, visits, WriterOptions{BlockSize: size}, "")
+
+		if report, err := Verify(path); err != nil || !report.Ok() {
+			t.Errorf("Case %d: file failed verification: %v %v", i, err, report.Problems)
+		}
+
+		db, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if db.header.blockSize != size {
+			t.Errorf("Case %d: wrong block size: want: %d got: %d", i, size, db.header.blockSize)
+		}
+
+		found := fetchSpaceIndex(db, []byte("Kingsbridge"), "type", "visit")
+		db.Close()
+
+		if expected == nil {
+			expected = found
+		} else if !reflect.DeepEqual(found, expected) {
+			t.Errorf("Case %d: wrong events: wanted %d events, found %d", i, len(expected), len(found))
+		}
+	}
+
+	if len(expected) == 0 {
+		t.Errorf("Expected events to be found")
+	}
+
+	for _, size := range []int{-1, MAX_BLOCK_SIZE + 1} {
+		if _, err := NewWithOptions("tmp/blocksize.esdb", WriterOptions{BlockSize: size}); err == nil {
+			t.Errorf("Expected error for block size %d", size)
+		}
+	}
+}
+
+func TestWriterUnregisteredCodec(t *testing.T) {
+	os.MkdirAll("tmp", 0755)
+
+	if _, err := NewWithOptions("tmp/unregistered.esdb", WriterOptions{Codec: unregisteredCodec{}}); err == nil {
+		t.Errorf("Expected error for unregistered codec")
+	}
+}
+
+type unregisteredCodec struct{}
+
+func (unregisteredCodec) Encoding() int                          { return 100 }
+func (unregisteredCodec) Encode(src []byte) ([]byte, error)      { return src, nil }
+func (unregisteredCodec) Decode(dst, src []byte) ([]byte, error) { return src, nil }
+
+func TestWriterAtomic(t *testing.T) {
+	visits := readVisits("testdata/ten_thousand_visits.csv", 1000)
+	os.MkdirAll("tmp/atomic", 0755)
+
+	w, err := New("tmp/atomic/visits.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range visits {
+		w.Add([]byte(e.City), e.data, e.Timestamp, e.Host, map[string]string{"visitor": e.Visitor})
+	}
+
+	w.Flush([]byte(visits[0].City))
+
+	// Nothing is found at the path until it's been written.
+	if _, err = os.Stat("tmp/atomic/visits.esdb"); !os.IsNotExist(err) {
+		t.Errorf("Expected partly written file not to be found: %v", err)
+	}
+
+	// A file being written at the same path isn't replaced.
+	other, err := New("tmp/atomic/visits.esdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other.Add([]byte("a"), []byte("1"), 1, "", nil)
+
+	if err = w.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	written, _ := ioutil.ReadFile("tmp/atomic/visits.esdb")
+
+	if err = other.Write(); !os.IsExist(err) {
+		t.Errorf("Expected error writing a file which has been created since: %v", err)
+	}
+
+	if found, _ := ioutil.ReadFile("tmp/atomic/visits.esdb"); !bytes.Equal(found, written) {
+		t.Errorf("Expected the file written first to be kept")
+	}
+
+	if stat, err := os.Stat("tmp/atomic/visits.esdb"); err != nil || stat.Mode().Perm()&0600 != 0600 {
+		t.Errorf("Expected written file to be found: %v", err)
+	}
+
+	if err = w.Abort(); err != nil {
+		t.Errorf("Expected aborting a written Writer to do nothing: %v", err)
+	}
+
+	if _, err = New("tmp/atomic/visits.esdb"); !os.IsExist(err) {
+		t.Errorf("Expected error creating an existing file: %v", err)
+	}
+
+	if report, err := Verify("tmp/atomic/visits.esdb"); err != nil || !report.Ok() {
+		t.Errorf("Written file failed verification: %v %v", err, report)
+	}
+
+	// An aborted file, with spilled events.
+	os.MkdirAll("tmp/atomic/spill", 0755)
+
+	w, err = NewWithOptions("tmp/atomic/aborted.esdb", WriterOptions{MemoryLimit: 1024, TempDir: "tmp/atomic/spill"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range visits {
+		w.Add([]byte(e.City), e.data, e.Timestamp, e.Host, nil)
+	}
+
+	w.Flush([]byte(visits[0].City))
+
+	if err = w.Abort(); err != nil {
+		t.Errorf("Failed to abort: %v", err)
+	}
+
+	if err = w.Add([]byte("a"), []byte("1"), 1, "", nil); err == nil {
+		t.Errorf("Expected error adding to an aborted Writer")
+	}
+
+	if files, _ := ioutil.ReadDir("tmp/atomic/spill"); len(files) != 0 {
+		t.Errorf("Expected spilled events to be removed, found %d files", len(files))
+	}
+
+	// Writers which fail to write their files are aborted.
+	w, _ = New("tmp/atomic/failed.esdb")
+	w.Add([]byte("a"), []byte("1"), 1, "", nil)
+	w.file.Close()
+
+	if err = w.Write(); err == nil {
+		t.Errorf("Expected error writing to a closed file")
+	}
+
+	files, _ := ioutil.ReadDir("tmp/atomic")
+	names := make([]string, 0, len(files))
+
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+
+	if len(names) != 1 || names[0] != "visits.esdb" {
+		t.Errorf("Expected only the written file: %v", names)
+	}
+}
+
 func BenchmarkWriteTenThousandEvents(b *testing.B) {
 	visits := readVisits("testdata/ten_thousand_visits.csv", 10000)
 